@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BatchReport tracks a run over the whole collection, such as a rethumb:
+// progress while it goes, then the artists that failed and why.
+type BatchReport struct {
+	Started   time.Time
+	Finished  time.Time
+	Running   bool
+	Total     int
+	Done      int
+	Succeeded int
+	Failures  []BatchFailure
+}
+
+type BatchFailure struct {
+	ID   int
+	Name string
+	Msg  string
+}
+
+// batchMu guards the reports of runs started from the admin page. Workers
+// take it only after releasing storeMu.
+var batchMu sync.Mutex
+
+// snapshotReport copies report under batchMu for rendering; nil stays nil.
+func snapshotReport(report *BatchReport) *BatchReport {
+	batchMu.Lock()
+	defer batchMu.Unlock()
+	if report == nil {
+		return nil
+	}
+	copied := *report
+	copied.Failures = append([]BatchFailure(nil), report.Failures...)
+	return &copied
+}
+
+// Admin page: whole-collection maintenance, run from the browser.
+func adminPage(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Artists     int
+		BrokenLinks int
+		Duplicates  int
+		Rethumb     *BatchReport
+		LinkCheck   *BatchReport
+	}{
+		Artists:     len(globalMasterList),
+		BrokenLinks: len(brokenLinks()),
+		Duplicates:  len(duplicateGroups()),
+		Rethumb:     snapshotReport(rethumbLast),
+		LinkCheck:   snapshotReport(linkCheckLast),
+	}
+	err := templates.ExecuteTemplate(w, "admin_page", data)
+	if err != nil {
+		http.Error(w, "Template error: "+err.Error(), 500)
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An artist's cover, the image on its grid card, is the one held in
+// ArtistRecord's own fields, so thumbnail jobs and retries, crops,
+// duplicate checks and palettes all work on it alone. Further images
+// follow it in ArtistRecord.Images, in carousel order, and any of them can
+// be made the cover. Rebuilds and link checks cover both.
+
+// maxArtistImages caps the further images an artist can have.
+const maxArtistImages = 12
+
+// imageFailed marks a further image whose thumbnail could not be made.
+// Unlike a cover it gets no placeholder or retries; it can be removed and
+// added again.
+const imageFailed = "failed"
+
+// ArtistImage is one further image of an artist, thumbnailed uncropped.
+type ArtistImage struct {
+	ID          int    // unique within the artist; 0 stands for the cover
+	ImgURL      string // or imgSourceUploaded
+	Thumb       string
+	ThumbWidths []int
+	Original    string
+	ImageHash   uint64
+	Hashed      bool // as for ArtistRecord.Hashed
+	Palette     Palette
+	Status      string // thumbDone, thumbPending or imageFailed
+	Msg         string // why the thumbnail failed
+	Link        LinkCheck
+}
+
+func (img ArtistImage) Srcset() string {
+	return srcset(img.Thumb, img.ThumbWidths)
+}
+
+// ArtistImagesData is the artist_images partial: the edit form's list of
+// images, with the outcome of the last change.
+type ArtistImagesData struct {
+	ArtistRecord
+	Msg string
+}
+
+// LastIndex is the index of the last further image, whose move down
+// button is disabled.
+func (d ArtistImagesData) LastIndex() int { return len(d.Images) - 1 }
+
+// ImagesPending reports whether a further image is still being fetched,
+// so the list keeps polling.
+func (rec ArtistRecord) ImagesPending() bool {
+	return slices.ContainsFunc(rec.Images, func(img ArtistImage) bool { return img.Status == thumbPending })
+}
+
+// CarouselImage is one slide of the gallery preview panel.
+type CarouselImage struct {
+	Thumb  string `json:"thumb"`
+	Srcset string `json:"srcset"`
+	Full   string `json:"full,omitempty"` // archived original, if any
+}
+
+// CarouselImages lists the cover and then every further image that has
+// a thumbnail.
+func (rec ArtistRecord) CarouselImages() []CarouselImage {
+	var slides []CarouselImage
+	if rec.Thumb != "" {
+		slide := CarouselImage{Thumb: "/images/" + rec.Thumb, Srcset: rec.Srcset()}
+		if rec.Original != "" {
+			slide.Full = fmt.Sprintf("/artists/original/%d", rec.ID)
+		}
+		slides = append(slides, slide)
+	}
+	for _, img := range rec.Images {
+		if img.Status != thumbDone {
+			continue
+		}
+		slide := CarouselImage{Thumb: "/images/" + img.Thumb, Srcset: img.Srcset()}
+		if img.Original != "" {
+			slide.Full = fmt.Sprintf("/artists/original/%d?image=%d", rec.ID, img.ID)
+		}
+		slides = append(slides, slide)
+	}
+	return slides
+}
+
+// ImagesJSON is CarouselImages for the grid_item's Alpine data.
+func (rec ArtistRecord) ImagesJSON() string {
+	slides := rec.CarouselImages()
+	if slides == nil {
+		slides = []CarouselImage{}
+	}
+	data, err := json.Marshal(slides)
+	if err != nil {
+		return "[]"
+	}
+	return string(data)
+}
+
+func findImageIndex(rec *ArtistRecord, imageID int) int {
+	return slices.IndexFunc(rec.Images, func(img ArtistImage) bool { return img.ID == imageID })
+}
+
+// newThumbName names a fresh thumbnail for an artist's cover (imageID 0)
+// or one of its further images.
+func newThumbName(artistID, imageID int) string {
+	if imageID == 0 {
+		return fmt.Sprintf("%d-%d.jpg", artistID, time.Now().UnixMilli())
+	}
+	return fmt.Sprintf("%d-%d-%d.jpg", artistID, imageID, time.Now().UnixMilli())
+}
+
+// addArtistImage appends a further image to rec, from an upload already
+// prepared, or else fetched from imgURL in the background. Call with
+// storeMu held; the caller saves the master list.
+func addArtistImage(rec *ArtistRecord, up *preparedUpload, imgURL string) error {
+	if len(rec.Images) >= maxArtistImages {
+		return &ImageError{Msg: fmt.Sprintf("An artist can have at most %d further images.", maxArtistImages)}
+	}
+	// IDs only go up, so a job or page for a removed image can't land on
+	// a later one
+	rec.LastImageID++
+	img := ArtistImage{ID: rec.LastImageID, ImgURL: imgURL}
+
+	if up != nil {
+		img.ImgURL = imgSourceUploaded
+		setImageThumbnail(&img, up.keep(newThumbName(rec.ID, img.ID)), up.Thumb)
+		setImageOriginal(&img, up.Data)
+		rec.Images = append(rec.Images, img)
+		return nil
+	}
+
+	if !fetchableURL(imgURL) {
+		return &ImageError{Msg: "Give an image URL or file to add."}
+	}
+	rec.Images = append(rec.Images, img)
+	queueArtistImage(rec, &rec.Images[len(rec.Images)-1])
+	return nil
+}
+
+// queueArtistImage marks img pending and queues a job for its ImgURL.
+// Call with storeMu held.
+func queueArtistImage(rec *ArtistRecord, img *ArtistImage) {
+	img.Status, img.Msg = thumbPending, ""
+	if !thumbQueue.Enqueue(thumbJob{ArtistID: rec.ID, ImageID: img.ID, URL: img.ImgURL}) {
+		img.Status, img.Msg = imageFailed, "Too many thumbnails waiting."
+	}
+}
+
+// setImageOriginal archives data as img's original, replacing the previous
+// one. Failing to archive is logged but doesn't fail the thumbnail. Call
+// with storeMu held.
+func setImageOriginal(img *ArtistImage, data []byte) {
+	name, err := saveOriginal(data)
+	if err != nil {
+		log.Printf("archiving original for image %d: %v", img.ID, err)
+		return
+	}
+	old := img.Original
+	img.Original = name
+	if old != name {
+		removeOriginal(old)
+	}
+}
+
+// setImageThumbnail makes the files createThumbnail saved as filename the
+// thumbnail of img.
+func setImageThumbnail(img *ArtistImage, filename string, thumb Thumbnail) {
+	img.Thumb, img.ThumbWidths = filename, thumb.Widths
+	img.ImageHash, img.Hashed, img.Palette = thumb.Hash, true, thumb.Palette
+	img.Status, img.Msg = thumbDone, ""
+}
+
+// applyImageJob records the outcome of a job for a further image. A result
+// for an image removed or replaced meanwhile is thrown away. Call with
+// storeMu held.
+func applyImageJob(job thumbJob, thumbFile string, data []byte, thumb Thumbnail, err error) {
+	i := findArtistIndex(job.ArtistID)
+	j := -1
+	if i != -1 {
+		j = findImageIndex(&globalMasterList[i], job.ImageID)
+	}
+	if j == -1 || globalMasterList[i].Images[j].ImgURL != job.URL || globalMasterList[i].Images[j].Status != thumbPending {
+		if err == nil {
+			removeThumbFiles(thumbFile, thumb.Widths)
+		}
+		return
+	}
+
+	rec := &globalMasterList[i]
+	img := &rec.Images[j]
+	if err != nil {
+		log.Printf("thumbnail error for %s: %v", job.URL, err)
+		img.Status, img.Msg = imageFailed, imageErrorMsg(err)
+	} else {
+		setImageThumbnail(img, thumbFile, thumb)
+		img.Link = LinkCheck{Checked: time.Now()} // it just served an image
+		setImageOriginal(img, data)
+	}
+
+	if err := saveMasterListInternal(); err != nil {
+		log.Printf("thumbnail: saving master list: %v", err)
+	}
+	publishArtist("artist-updated", *rec)
+}
+
+// removeArtistImage deletes further image j and its files. Call with
+// storeMu held.
+func removeArtistImage(rec *ArtistRecord, j int) {
+	img := rec.Images[j]
+	rec.Images = slices.Delete(rec.Images, j, j+1)
+	removeThumbFiles(img.Thumb, img.ThumbWidths)
+	removeOriginal(img.Original)
+}
+
+// makeCover swaps further image j with the cover. The old cover takes its
+// place in the list, keeping its thumbnail as framed, unless it was only a
+// placeholder or still being fetched, in which case it is dropped. The
+// crop is reset, as its focal point was picked on the old picture. Call
+// with storeMu held.
+func makeCover(rec *ArtistRecord, j int) {
+	img := rec.Images[j]
+	oldOriginal := ""
+	if rec.ThumbStatus == thumbDone && rec.Thumb != "" {
+		rec.Images[j] = ArtistImage{
+			ID:          img.ID,
+			ImgURL:      rec.ImgURL,
+			Thumb:       rec.Thumb,
+			ThumbWidths: rec.ThumbWidths,
+			Original:    rec.Original,
+			ImageHash:   rec.ImageHash,
+			Hashed:      rec.Hashed,
+			Palette:     rec.Palette,
+			Link:        rec.Link,
+		}
+	} else {
+		rec.Images = slices.Delete(rec.Images, j, j+1)
+		removeThumbFiles(rec.Thumb, rec.ThumbWidths)
+		oldOriginal = rec.Original
+	}
+
+	rec.ImgURL, rec.Thumb, rec.ThumbWidths, rec.Original = img.ImgURL, img.Thumb, img.ThumbWidths, img.Original
+	rec.ImageHash, rec.Hashed, rec.Palette = img.ImageHash, img.Hashed, img.Palette
+	rec.Crop = Crop{}
+	rec.Link = img.Link
+	rec.ThumbStatus, rec.ThumbMsg, rec.ThumbAttempts = thumbDone, "", 0
+	removeOriginal(oldOriginal)
+}
+
+// readImageLine applies one "x" line of the master list to img. A further
+// image is written as "xi:" (its ID), "xu:" (URL), "xt:" (thumbnail), then
+// as needed "xw:", "xo:", "xh:", "xp:", "xs:" and "xm:", mirroring the
+// cover's "w:", "o:", "ph:", "pl:", "ts:" and "tm:", and "xlc:" and "xlm:"
+// for "lc:" and "lm:". An "xl:" line before them holds the artist's
+// LastImageID.
+func readImageLine(img *ArtistImage, line string) {
+	key, value, _ := strings.Cut(line, ":")
+	value = strings.TrimSpace(value)
+	switch key {
+	case "xu":
+		img.ImgURL = value
+	case "xt":
+		img.Thumb = value
+	case "xw":
+		for _, w := range strings.Split(value, ",") {
+			if n, err := strconv.Atoi(w); err == nil {
+				img.ThumbWidths = append(img.ThumbWidths, n)
+			}
+		}
+	case "xo":
+		img.Original = value
+	case "xh":
+		var err error
+		img.ImageHash, err = strconv.ParseUint(value, 16, 64)
+		img.Hashed = err == nil
+	case "xp":
+		img.Palette = ParsePalette(value)
+	case "xs":
+		img.Status = value
+	case "xm":
+		img.Msg = value
+	case "xlc":
+		img.Link.Checked, _ = time.Parse(time.RFC3339, value)
+	case "xlm":
+		img.Link.Msg = value
+	}
+}
+
+func writeImageLines(builder *strings.Builder, img ArtistImage) {
+	builder.WriteString(fmt.Sprintf("xi:%d\nxu:%s\nxt:%s\n", img.ID, img.ImgURL, img.Thumb))
+	if len(img.ThumbWidths) > 0 {
+		builder.WriteString(fmt.Sprintf("xw:%s\n", joinInts(img.ThumbWidths)))
+	}
+	if img.Original != "" {
+		builder.WriteString(fmt.Sprintf("xo:%s\n", img.Original))
+	}
+	if img.Hashed {
+		builder.WriteString(fmt.Sprintf("xh:%016x\n", img.ImageHash))
+	}
+	if len(img.Palette) > 0 {
+		builder.WriteString(fmt.Sprintf("xp:%s\n", img.Palette))
+	}
+	if img.Status != thumbDone {
+		builder.WriteString(fmt.Sprintf("xs:%s\nxm:%s\n", img.Status, img.Msg))
+	}
+	if !img.Link.Checked.IsZero() {
+		builder.WriteString(fmt.Sprintf("xlc:%s\nxlm:%s\n", img.Link.Checked.Format(time.RFC3339), img.Link.Msg))
+	}
+}
+
+// htmx handler for the edit form's image list. GET renders it, polled while
+// an image is being fetched. POST changes it, by form value action:
+// "add" (img_url or img_file), or "up", "down", "cover" or "remove" for
+// the further image whose ID is in image. Registered without locked(), like
+// submitArtistAddFormHandler.
+func artistImagesHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/artists/images/"))
+
+	var formErr error
+	var prepared *preparedUpload
+	if r.Method == http.MethodPost {
+		formErr = parseUploadForm(w, r)
+		if formErr == nil && r.FormValue("action") == "add" {
+			var upload []byte
+			upload, formErr = formImage(r)
+			if upload != nil {
+				prepared, formErr = prepareUpload(upload, Crop{})
+			}
+		}
+	}
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	i := findArtistIndex(id)
+	if i == -1 {
+		prepared.discard()
+		http.Error(w, "Artist not found", 404)
+		return
+	}
+	rec := &globalMasterList[i]
+	data := ArtistImagesData{}
+	coverChanged := false
+
+	if r.Method == http.MethodPost {
+		err := formErr
+		imageID, _ := strconv.Atoi(r.FormValue("image"))
+		j := findImageIndex(rec, imageID)
+		changed := true
+		switch action := r.FormValue("action"); {
+		case err != nil:
+		case action == "add":
+			err = addArtistImage(rec, prepared, strings.TrimSpace(r.FormValue("img_url")))
+			if err != nil {
+				prepared.discard()
+			}
+		case j == -1:
+			err = &ImageError{Msg: "That image is no longer there."}
+		case action == "up" && j > 0:
+			rec.Images[j-1], rec.Images[j] = rec.Images[j], rec.Images[j-1]
+		case action == "down" && j < len(rec.Images)-1:
+			rec.Images[j], rec.Images[j+1] = rec.Images[j+1], rec.Images[j]
+		case action == "cover" && rec.Images[j].Status == thumbDone:
+			makeCover(rec, j)
+			coverChanged = true
+		case action == "remove":
+			removeArtistImage(rec, j)
+		default:
+			changed = false // e.g. moving the first image up, or a pending one to cover
+		}
+		if err != nil {
+			data.Msg = imageErrorMsg(err)
+		} else if changed {
+			if err := saveMasterListInternal(); err != nil {
+				log.Printf("artist images: saving master list: %v", err)
+			}
+			publishArtist("artist-updated", *rec)
+			setHXTrigger(w, "artist-updated", artistDetail(*rec))
+		}
+	}
+
+	// A new cover changes the edit form's image URL and crop too; left
+	// stale, Save Changes would put the old cover back over it
+	if coverChanged {
+		w.Header().Set("HX-Retarget", "#edit-form-target")
+		w.Header().Set("HX-Reswap", "innerHTML")
+		err := templates.ExecuteTemplate(w, "edit_form_content", EditFormData{ArtistRecord: *rec})
+		if err != nil {
+			http.Error(w, "Template error: "+err.Error(), 500)
+		}
+		return
+	}
+
+	data.ArtistRecord = *rec
+	err := templates.ExecuteTemplate(w, "artist_images", data)
+	if err != nil {
+		http.Error(w, "Template error: "+err.Error(), 500)
+	}
+}
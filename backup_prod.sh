@@ -3,6 +3,6 @@
 rm -rf backup_data backup_images
 mkdir -p backup_data backup_images
 cp data/* backup_data/
-cp images/* backup_images/
+cp -r images/* backup_images/
 echo "prod backed up clean:"
 ls backup_data/ backup_images/
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+)
+
+// Maintenance commands, run in place of the server:
+//
+//	artistapp backfill-sizes   add missing srcset widths to existing thumbnails
+//	artistapp rethumb          rebuild every thumbnail with current settings
+//	artistapp check-links      list artists whose image URL no longer works
+//
+// They rewrite the data files, so stop the server first.
+const commandUsage = `usage: artistapp [command]
+
+With no command, runs the web server. Commands:
+  backfill-sizes   add missing srcset widths to existing thumbnails
+  rethumb          rebuild every thumbnail with current settings
+  check-links      list artists whose image URL no longer works
+`
+
+// runCommand runs the maintenance command named in args and returns the
+// process exit code.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "backfill-sizes":
+		return rebuildThumbnails("backfill-sizes", missingWidths)
+	case "rethumb":
+		return rebuildThumbnails("rethumb", func([]int) bool { return true })
+	case "check-links":
+		return checkLinks()
+	case "help", "-h", "--help":
+		fmt.Print(commandUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n%s", args[0], commandUsage)
+		return 2
+	}
+}
+
+// rebuildThumbnails runs rethumbAll over the thumbnails picked by want and
+// lists any failures. Old thumbnails stay for those that fail.
+func rebuildThumbnails(command string, want func(widths []int) bool) int {
+	report := &BatchReport{Started: time.Now(), Running: true}
+	rethumbAll(want, report)
+	for _, f := range report.Failures {
+		log.Printf("%d %s: %s", f.ID, f.Name, f.Msg)
+	}
+	log.Printf("%s: %d rebuilt, %d failed, in %s", command, report.Succeeded, len(report.Failures),
+		report.Finished.Sub(report.Started).Round(time.Second))
+	if len(report.Failures) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkLinks runs checkAllLinks and lists the broken links, which are
+// also saved for the admin page's report.
+func checkLinks() int {
+	report := &BatchReport{Started: time.Now(), Running: true}
+	checkAllLinks(report)
+	for _, f := range report.Failures {
+		log.Printf("%d %s: %s", f.ID, f.Name, f.Msg)
+	}
+	log.Printf("check-links: %d ok, %d broken, in %s", report.Succeeded, len(report.Failures),
+		report.Finished.Sub(report.Started).Round(time.Second))
+	if len(report.Failures) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// missingWidths reports whether a thumbnail saved at widths lacks a
+// configured extra width. Widths skipped because the source is too small
+// count as missing, so a small image is rebuilt from its original on every
+// run; that is cheap next to fetching.
+func missingWidths(widths []int) bool {
+	for _, w := range thumbExtraWidths {
+		if !slices.Contains(widths, w) {
+			return true
+		}
+	}
+	return false
+}
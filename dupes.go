@@ -0,0 +1,103 @@
+package main
+
+import (
+	"image"
+	"math/bits"
+	"net/http"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// dupMaxDistance is how many of the 64 hash bits two images may differ by
+// and still count as the same picture. Resizing, recompression and small
+// edits stay well under it; unrelated images differ by about half.
+const dupMaxDistance = 6
+
+// imageHash is a difference hash of img: it is shrunk to 9x8 greys and each
+// bit says whether a pixel is darker than its right neighbour. Copies of a
+// picture at other sizes, qualities or formats hash the same or nearly so.
+func imageHash(img image.Image) uint64 {
+	small := imaging.Grayscale(imaging.Resize(img, 9, 8, imaging.Box))
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		row := small.Pix[y*small.Stride:]
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if row[x*4] < row[(x+1)*4] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func hashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// similarImages reports whether a and b have near-identical images. Only
+// real thumbnails are compared, not placeholders.
+func similarImages(a, b ArtistRecord) bool {
+	return a.ID != b.ID && a.Hashed && b.Hashed &&
+		a.ThumbStatus == thumbDone && b.ThumbStatus == thumbDone &&
+		hashDistance(a.ImageHash, b.ImageHash) <= dupMaxDistance
+}
+
+// Duplicates lists the other artists whose image looks the same as rec's,
+// for the warning shown after an add or edit. Call with storeMu held.
+func (rec ArtistRecord) Duplicates() []ArtistRecord {
+	var dups []ArtistRecord
+	for _, other := range globalMasterList {
+		if similarImages(rec, other) {
+			dups = append(dups, other)
+		}
+	}
+	return dups
+}
+
+// duplicateGroups gathers artists with near-identical images. Similarity is
+// followed transitively, so a group can hold images further apart than
+// dupMaxDistance by way of one between them. Call with storeMu held.
+func duplicateGroups() [][]ArtistRecord {
+	parent := make([]int, len(globalMasterList))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range globalMasterList {
+		for j := i + 1; j < len(globalMasterList); j++ {
+			if similarImages(globalMasterList[i], globalMasterList[j]) {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	byRoot := map[int][]ArtistRecord{}
+	for i, rec := range globalMasterList {
+		byRoot[find(i)] = append(byRoot[find(i)], rec)
+	}
+	var groups [][]ArtistRecord
+	for _, group := range byRoot {
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0].ID < groups[j][0].ID })
+	return groups
+}
+
+// Report page: groups of artists sharing what looks like the same image,
+// each with a shortcut to its edit form
+func duplicatesPage(w http.ResponseWriter, r *http.Request) {
+	err := templates.ExecuteTemplate(w, "duplicates_page", duplicateGroups())
+	if err != nil {
+		http.Error(w, "Template error: "+err.Error(), 500)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ArtistDetail mirrors the artist object each grid_item hands to the
+// gallery's Alpine promptStore, so an event detail can replace a working
+// set entry as-is. IDs go out as strings, matching the template.
+type ArtistDetail struct {
+	ID     int             `json:"id,string"`
+	Name   string          `json:"name"`
+	Desc   string          `json:"desc"`
+	Thumb  string          `json:"thumb"`
+	Srcset string          `json:"srcset"`
+	Images []CarouselImage `json:"images"`
+	Google string          `json:"google"`
+}
+
+func artistDetail(rec ArtistRecord) *ArtistDetail {
+	thumb := rec.Thumb
+	if thumb == "" {
+		thumb = fmt.Sprintf("%d.jpg", rec.ID)
+	}
+	return &ArtistDetail{
+		ID:     rec.ID,
+		Name:   rec.Name,
+		Desc:   rec.Description,
+		Thumb:  "/images/" + thumb,
+		Srcset: rec.Srcset(),
+		Images: rec.CarouselImages(),
+		Google: "https://www.google.com/search?q=art+by+" + url.QueryEscape(rec.Name),
+	}
+}
+
+// setHXTrigger sets an HX-Trigger header firing one event with detail,
+// JSON-encoded so names and descriptions with quotes survive.
+func setHXTrigger(w http.ResponseWriter, event string, detail any) {
+	payload, err := json.Marshal(map[string]any{event: detail})
+	if err != nil {
+		log.Printf("events: encoding HX-Trigger %s: %v", event, err)
+		return
+	}
+	w.Header().Set("HX-Trigger", string(payload))
+}
+
+// StoreEvent is one change to the master list, broadcast to every open
+// gallery over /events. HTML carries the rendered grid_item and Artist the
+// new record for adds and updates; deletes only need the ID.
+type StoreEvent struct {
+	Type   string        `json:"-"`
+	ID     int           `json:"id,string"`
+	HTML   string        `json:"html,omitempty"`
+	Artist *ArtistDetail `json:"artist,omitempty"`
+}
+
+// Broker fans store events out to any number of SSE subscribers. Each
+// subscriber gets a small buffered channel; a client that falls behind
+// misses events rather than blocking the handler that published them.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[chan StoreEvent]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[chan StoreEvent]struct{})}
+}
+
+func (b *Broker) Subscribe() chan StoreEvent {
+	ch := make(chan StoreEvent, 16)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *Broker) Unsubscribe(ch chan StoreEvent) {
+	b.mu.Lock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+	b.mu.Unlock()
+}
+
+func (b *Broker) Publish(ev StoreEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			log.Printf("events: dropping %s for slow subscriber", ev.Type)
+		}
+	}
+}
+
+var broker = NewBroker()
+
+// publishArtist renders the grid_item for rec and broadcasts it.
+func publishArtist(eventType string, rec ArtistRecord) {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "grid_item", rec); err != nil {
+		log.Printf("events: rendering grid_item for %d: %v", rec.ID, err)
+		return
+	}
+	broker.Publish(StoreEvent{Type: eventType, ID: rec.ID, HTML: buf.String(), Artist: artistDetail(rec)})
+}
+
+// htmx handler: Server-Sent Events stream of master list changes
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := broker.Subscribe()
+	defer broker.Unsubscribe(ch)
+
+	// Tell the browser how long to wait before reconnecting, and get the
+	// headers out so EventSource fires "open" straight away.
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	// Comment lines keep proxies from closing an idle stream.
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev := <-ch:
+			payload, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("events: encoding %s: %v", ev.Type, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, payload); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
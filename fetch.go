@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	fetchConnectTimeout = 5 * time.Second
+	fetchTotalTimeout   = 30 * time.Second
+	fetchMaxBytes       = 15 << 20 // 15 MB
+	fetchMaxRedirects   = 5
+)
+
+var (
+	errTooManyRedirects = errors.New("too many redirects")
+	errBlockedAddress   = errors.New("address not allowed")
+)
+
+// FetchPolicy decides which addresses image fetches may connect to.
+// Anything that could reach this machine or the LAN is refused unless it
+// falls inside one of the Allow prefixes.
+type FetchPolicy struct {
+	Allow []netip.Prefix
+}
+
+// cgnat is the carrier-grade NAT range, private in practice but not
+// covered by netip.Addr.IsPrivate.
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// Check reports whether connecting to ip is allowed.
+func (p *FetchPolicy) Check(ip netip.Addr) error {
+	ip = ip.Unmap()
+	for _, prefix := range p.Allow {
+		if prefix.Contains(ip) {
+			return nil
+		}
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || cgnat.Contains(ip) {
+		return fmt.Errorf("%w: %s", errBlockedAddress, ip)
+	}
+	return nil
+}
+
+// control runs after DNS resolution and before each connection, so the
+// check sees the address actually dialled on every redirect hop and can't
+// be dodged by a hostname that resolves differently the second time.
+func (p *FetchPolicy) control(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errBlockedAddress, address)
+	}
+	return p.Check(addrPort.Addr())
+}
+
+// ParseFetchAllow reads a comma-separated list of IPs and CIDR prefixes,
+// e.g. "192.168.1.20,10.0.0.0/8", as given in IMAGE_FETCH_ALLOW.
+func ParseFetchAllow(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// fetchPolicy is filled from IMAGE_FETCH_ALLOW at startup.
+var fetchPolicy = &FetchPolicy{}
+
+// imageClient is used for every image download. Unlike http.DefaultClient
+// it gives up on slow hosts, long redirect chains and non-http schemes, and
+// only connects where fetchPolicy allows.
+var imageClient = NewImageClient(fetchPolicy)
+
+// NewImageClient returns an image download client enforcing policy. There
+// is deliberately no proxy support: through a proxy the policy would only
+// ever see the proxy's address.
+func NewImageClient(policy *FetchPolicy) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: fetchConnectTimeout,
+		Control: policy.control,
+	}
+	return &http.Client{
+		Timeout: fetchTotalTimeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   fetchConnectTimeout,
+			ResponseHeaderTimeout: fetchTotalTimeout / 2,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= fetchMaxRedirects {
+				return errTooManyRedirects
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// fetchImage downloads an image for thumbnailing. It gives up when ctx
+// does, which callers give the deadline of the background job doing the
+// fetch, and in any case after the client's fetchTotalTimeout. Every
+// failure is an *ImageError whose message says what went wrong.
+func fetchImage(ctx context.Context, imageURL string) ([]byte, error) {
+	u, err := url.Parse(imageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, &ImageError{Msg: "Image URL must be a full http:// or https:// address.", Err: err}
+	}
+
+	req, err := newImageRequest(ctx, http.MethodGet, u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return nil, fetchError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &ImageError{Msg: fmt.Sprintf("The image host answered %s.", resp.Status)}
+	}
+
+	if resp.ContentLength > fetchMaxBytes {
+		return nil, tooLargeError()
+	}
+
+	// Content-Type is only a first check; the bytes are sniffed below.
+	if err := checkImageContentType(resp); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, fetchMaxBytes+1))
+	if err != nil {
+		return nil, fetchError(err)
+	}
+	if len(data) > fetchMaxBytes {
+		return nil, tooLargeError()
+	}
+
+	kind, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if !decodableTypes[kind] {
+		return nil, &ImageError{Msg: fmt.Sprintf("The URL returned %s, not a JPEG, PNG, GIF or WebP image.", kind)}
+	}
+	return data, nil
+}
+
+// newImageRequest builds a request for an image URL as the image host
+// expects to see it.
+func newImageRequest(ctx context.Context, method, imageURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, imageURL, nil)
+	if err != nil {
+		return nil, &ImageError{Msg: "Image URL is not valid.", Err: err}
+	}
+	// Some CDNs refuse Go's default user agent
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; artistapp thumbnailer)")
+	req.Header.Set("Accept", "image/*")
+	return req, nil
+}
+
+// checkImageContentType refuses responses that say they are not an image,
+// typically an HTML error or login page.
+func checkImageContentType(resp *http.Response) error {
+	ctype := strings.ToLower(resp.Header.Get("Content-Type"))
+	if ctype != "" && !strings.HasPrefix(ctype, "image/") &&
+		!strings.HasPrefix(ctype, "application/octet-stream") &&
+		!strings.HasPrefix(ctype, "binary/octet-stream") {
+		kind, _, _ := strings.Cut(ctype, ";")
+		return &ImageError{Msg: fmt.Sprintf("The URL points to a %s page, not an image. Use the image's own address.", kind)}
+	}
+	return nil
+}
+
+func tooLargeError() error {
+	return &ImageError{Msg: fmt.Sprintf("The image is larger than %d MB.", fetchMaxBytes>>20)}
+}
+
+// fetchError turns a transport error into the message shown in the form.
+func fetchError(err error) error {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return &ImageError{Msg: "The image download was cancelled.", Err: err}
+	case errors.Is(err, errBlockedAddress):
+		return &ImageError{Msg: "That image host is on a local or private network, which is not allowed.", Err: err}
+	case errors.Is(err, errTooManyRedirects):
+		return &ImageError{Msg: fmt.Sprintf("The image URL redirected more than %d times.", fetchMaxRedirects), Err: err}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return &ImageError{Msg: "The image host took too long to respond.", Err: err}
+	case errors.As(err, &dnsErr):
+		return &ImageError{Msg: "Could not find the image host. Check the URL.", Err: err}
+	default:
+		return &ImageError{Msg: "Could not download the image.", Err: err}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func imageServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	}))
+}
+
+func TestFetchRefusesLoopbackByDefault(t *testing.T) {
+	srv := imageServer()
+	defer srv.Close()
+
+	client := NewImageClient(&FetchPolicy{})
+	resp, err := client.Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("fetch from loopback succeeded, want it refused")
+	}
+	if !errors.Is(err, errBlockedAddress) {
+		t.Fatalf("got %v, want errBlockedAddress", err)
+	}
+}
+
+func TestFetchAllowsListedLoopback(t *testing.T) {
+	srv := imageServer()
+	defer srv.Close()
+
+	allow, err := ParseFetchAllow("127.0.0.1, ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewImageClient(&FetchPolicy{Allow: allow})
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("fetch from allowed loopback: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestFetchRefusesRedirectToBlockedAddress(t *testing.T) {
+	// The target listens on another loopback address, outside the allow list
+	ln, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("no second loopback address: %v", err)
+	}
+	target := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("redirect target was reached")
+	}))
+	target.Listener.Close()
+	target.Listener = ln
+	target.Start()
+	defer target.Close()
+
+	origin := httptest.NewServer(http.RedirectHandler(target.URL+"/a.png", http.StatusFound))
+	defer origin.Close()
+
+	allow, err := ParseFetchAllow("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewImageClient(&FetchPolicy{Allow: allow})
+	resp, err := client.Get(origin.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("redirect to a blocked address was followed")
+	}
+	if !errors.Is(err, errBlockedAddress) {
+		t.Fatalf("got %v, want errBlockedAddress", err)
+	}
+}
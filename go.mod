@@ -4,4 +4,4 @@ go 1.23.5
 
 require github.com/disintegration/imaging v1.6.2
 
-require golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8 // indirect
+require golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8
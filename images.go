@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	_ "golang.org/x/image/webp" // registers WebP with image.Decode
+)
+
+// thumbWidth is the base thumbnail, ArtistRecord.Thumb. Each width in
+// thumbExtraWidths is saved next to it under thumbSizeName, for srcset.
+const thumbWidth = 200
+
+// thumbExtraWidths is set from THUMB_WIDTHS at startup.
+var thumbExtraWidths = []int{400}
+
+// ParseThumbWidths reads a comma-separated list of widths such as
+// "200,400,800" and returns the ones beyond thumbWidth, ascending.
+// thumbWidth itself may be listed, as it is always made; anything
+// narrower is an error, as srcset would never pick it.
+func ParseThumbWidths(list string) ([]int, error) {
+	seen := map[int]bool{thumbWidth: true}
+	var widths []int
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		w, err := strconv.Atoi(item)
+		if err != nil || w > 4096 {
+			return nil, fmt.Errorf("bad thumbnail width %q", item)
+		}
+		if w < thumbWidth {
+			return nil, fmt.Errorf("thumbnail width %d is below the base width %d", w, thumbWidth)
+		}
+		if !seen[w] {
+			seen[w] = true
+			widths = append(widths, w)
+		}
+	}
+	sort.Ints(widths)
+	return widths, nil
+}
+
+// thumbSizeName names the width-wide copy of thumbnail filename, e.g.
+// 12-1700000000000-400w.jpg.
+func thumbSizeName(filename string, width int) string {
+	return fmt.Sprintf("%s-%dw.jpg", strings.TrimSuffix(filename, filepath.Ext(filename)), width)
+}
+
+// Srcset lists the base thumbnail and its extra widths for an img srcset,
+// or "" when there is only the base.
+func (rec ArtistRecord) Srcset() string {
+	return srcset(rec.Thumb, rec.ThumbWidths)
+}
+
+// srcset lists the base thumbnail thumb and its extra widths.
+func srcset(thumb string, widths []int) string {
+	if thumb == "" || len(widths) == 0 {
+		return ""
+	}
+	parts := []string{fmt.Sprintf("/images/%s %dw", thumb, thumbWidth)}
+	for _, w := range widths {
+		parts = append(parts, fmt.Sprintf("/images/%s %dw", thumbSizeName(thumb, w), w))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Crop says how an artist's thumbnails are framed. Mode "" fits the whole
+// image; "square" or a "W:H" ratio crops to that shape around the focal
+// point, given as an offset from the centre in fractions of the width and
+// height (-0.5 to 0.5), so the zero value is a centred fit.
+type Crop struct {
+	Mode           string
+	FocusX, FocusY float64
+}
+
+// CropMode is one choice in the edit form's crop menu.
+type CropMode struct {
+	Value, Label string
+}
+
+var cropModes = []CropMode{
+	{"", "Fit whole image"},
+	{"square", "Square"},
+	{"3:4", "Portrait 3:4"},
+	{"4:3", "Landscape 4:3"},
+}
+
+// cropFromForm reads the edit form's crop menu and focal point, ignoring
+// unknown modes and clamping the focus to the image.
+func cropFromForm(r *http.Request) Crop {
+	var c Crop
+	for _, m := range cropModes {
+		if m.Value == r.FormValue("crop") {
+			c.Mode = m.Value
+		}
+	}
+	c.FocusX = focusOffset(r.FormValue("focus_x"))
+	c.FocusY = focusOffset(r.FormValue("focus_y"))
+	return c
+}
+
+func focusOffset(s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0
+	}
+	return max(-0.5, min(f, 0.5))
+}
+
+// aspect returns the crop's width:height ratio, or ok false for a fit.
+func (c Crop) aspect() (w, h int, ok bool) {
+	if c.Mode == "square" {
+		return 1, 1, true
+	}
+	ws, hs, found := strings.Cut(c.Mode, ":")
+	w, errW := strconv.Atoi(ws)
+	h, errH := strconv.Atoi(hs)
+	if !found || errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return 0, 0, false
+	}
+	return w, h, true
+}
+
+// cropImage cuts the largest rectangle of the crop's shape out of img,
+// centred on the focal point as far as the edges allow.
+func cropImage(img image.Image, c Crop) image.Image {
+	aw, ah, ok := c.aspect()
+	if !ok {
+		return img
+	}
+	b := img.Bounds()
+	cw, ch := b.Dx(), b.Dx()*ah/aw
+	if ch > b.Dy() {
+		cw, ch = b.Dy()*aw/ah, b.Dy()
+	}
+	x := b.Min.X + int((0.5+c.FocusX)*float64(b.Dx())) - cw/2
+	y := b.Min.Y + int((0.5+c.FocusY)*float64(b.Dy())) - ch/2
+	x = max(b.Min.X, min(x, b.Max.X-cw))
+	y = max(b.Min.Y, min(y, b.Max.Y-ch))
+	return imaging.Crop(img, image.Rect(x, y, x+cw, y+ch))
+}
+
+// removeThumbFiles deletes a thumbnail and its extra widths.
+// tempThumbName reserves a name in imagesDir for a thumbnail built before
+// storeMu is taken, when its final name can't be picked yet; no other
+// request can get the same one. renameThumbFiles moves it once locked.
+func tempThumbName() (string, error) {
+	if err := os.MkdirAll(imagesDir, 0755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(imagesDir, "tmp-*.jpg")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return filepath.Base(f.Name()), nil
+}
+
+// renameThumbFiles moves thumbnail from and its extra widths to the name
+// to. If the base file can't be moved, nothing is and from stays usable.
+func renameThumbFiles(from, to string, widths []int) error {
+	if err := os.Rename(filepath.Join(imagesDir, from), filepath.Join(imagesDir, to)); err != nil {
+		return err
+	}
+	for _, w := range widths {
+		err := os.Rename(filepath.Join(imagesDir, thumbSizeName(from, w)), filepath.Join(imagesDir, thumbSizeName(to, w)))
+		if err != nil {
+			log.Printf("renaming thumbnail: %v", err)
+		}
+	}
+	return nil
+}
+
+func removeThumbFiles(thumb string, widths []int) {
+	if thumb == "" {
+		return
+	}
+	_ = os.Remove(filepath.Join(imagesDir, thumb))
+	for _, w := range widths {
+		_ = os.Remove(filepath.Join(imagesDir, thumbSizeName(thumb, w)))
+	}
+}
+
+// ImageError is a thumbnail failure carrying a message fit to show in the
+// form, e.g. naming the image type we couldn't decode.
+type ImageError struct {
+	Msg string
+	Err error
+}
+
+func (e *ImageError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s (%v)", e.Msg, e.Err)
+	}
+	return e.Msg
+}
+
+func (e *ImageError) Unwrap() error { return e.Err }
+
+// imageErrorMsg is the form message for a thumbnail error.
+func imageErrorMsg(err error) string {
+	var ie *ImageError
+	if errors.As(err, &ie) {
+		return ie.Msg
+	}
+	return "Warning: could not create thumbnail from image URL."
+}
+
+func thumbnailExists(filename string) bool {
+	// thumbnailPath := filepath.Join("images", filename)
+
+	thumbnailPath := filepath.Join(imagesDir, filename)
+	if _, err := os.Stat(thumbnailPath); err == nil {
+		return true
+	}
+	return false
+}
+
+// fetchAndCreateThumbnail downloads imageURL and saves its thumbnails as
+// filename. It returns the downloaded image, so the caller can archive it,
+// and what createThumbnail made.
+func fetchAndCreateThumbnail(ctx context.Context, imageURL, filename string, crop Crop) ([]byte, Thumbnail, error) {
+	data, err := fetchImage(ctx, imageURL)
+	if err != nil {
+		return nil, Thumbnail{}, err
+	}
+	thumb, err := createThumbnail(data, filename, crop)
+	return data, thumb, err
+}
+
+// originalsDir, under imagesDir, keeps the full-size source images. Files
+// are named by content hash, so the same picture is only stored once.
+const originalsDir = "originals"
+
+var originalExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// saveOriginal archives image data and returns its file name within
+// originalsDir. Call with storeMu held, so removeOriginal can't race it.
+func saveOriginal(data []byte) (string, error) {
+	kind, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:]) + originalExts[kind]
+
+	dir := filepath.Join(imagesDir, originalsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return name, nil // already archived for another artist
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func readOriginal(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(imagesDir, originalsDir, name))
+}
+
+// removeOriginal deletes an archived original once no artist refers to it.
+// Call with storeMu held, after the record has let go of name.
+func removeOriginal(name string) {
+	if name == "" {
+		return
+	}
+	for _, rec := range globalMasterList {
+		if rec.Original == name {
+			return
+		}
+		for _, img := range rec.Images {
+			if img.Original == name {
+				return
+			}
+		}
+	}
+	_ = os.Remove(filepath.Join(imagesDir, originalsDir, name))
+}
+
+// setOriginal archives data as rec's original, replacing the previous one.
+// Failing to archive is logged but doesn't fail the thumbnail.
+func setOriginal(rec *ArtistRecord, data []byte) {
+	name, err := saveOriginal(data)
+	if err != nil {
+		log.Printf("archiving original for %d: %v", rec.ID, err)
+		return
+	}
+	replaceOriginal(rec, name)
+}
+
+// replaceOriginal makes the archived file name rec's original, removing
+// the previous one unless something else still uses it.
+func replaceOriginal(rec *ArtistRecord, name string) {
+	old := rec.Original
+	rec.Original = name
+	if old != name {
+		removeOriginal(old)
+	}
+}
+
+// Thumbnail is what createThumbnail made of an image.
+type Thumbnail struct {
+	Widths  []int   // extra widths saved, see thumbExtraWidths
+	Hash    uint64  // imageHash of the whole image, before cropping
+	Palette Palette // dominant colours of the thumbnail
+}
+
+// createThumbnail decodes image data of any supported format, crops it,
+// and saves a thumbWidth-wide JPEG thumbnail as filename in imagesDir, plus
+// a copy at each of thumbExtraWidths the source is wide enough for.
+func createThumbnail(data []byte, filename string, crop Crop) (Thumbnail, error) {
+	// Ensure images dir exists
+	if err := os.MkdirAll(imagesDir, 0755); err != nil {
+		return Thumbnail{}, err
+	}
+
+	img, err := decodeImage(data)
+	if err != nil {
+		return Thumbnail{}, err
+	}
+	img = flatten(img)
+	// Hashed uncropped, so two artists framing the same picture still match
+	hash := imageHash(img)
+	img = cropImage(img, crop)
+
+	resized := imaging.Resize(img, thumbWidth, 0, imaging.Lanczos)
+	outPath := filepath.Join(imagesDir, filename)
+
+	if err := imaging.Save(resized, outPath); err != nil {
+		return Thumbnail{}, fmt.Errorf("error saving image: %v", err)
+	}
+
+	// Larger copies for high-DPI screens; never upscale
+	var widths []int
+	for _, w := range thumbExtraWidths {
+		if w > img.Bounds().Dx() {
+			break
+		}
+		resized := imaging.Resize(img, w, 0, imaging.Lanczos)
+		if err := imaging.Save(resized, filepath.Join(imagesDir, thumbSizeName(filename, w))); err != nil {
+			removeThumbFiles(filename, widths)
+			return Thumbnail{}, fmt.Errorf("error saving image: %v", err)
+		}
+		widths = append(widths, w)
+	}
+
+	return Thumbnail{Widths: widths, Hash: hash, Palette: imagePalette(resized)}, nil
+}
+
+// maxImagePixels caps the size of an image decodeImage will decode. A small
+// file can claim huge dimensions, and decoded it takes 4 bytes a pixel.
+const maxImagePixels = 50_000_000
+
+// Formats decodeImage accepts, by sniffed MIME type.
+var decodableTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true, // first frame only
+	"image/webp": true,
+}
+
+// decodeImage sniffs the format from the data itself, since URLs and
+// Content-Type headers are often wrong, then decodes it upright: phone
+// photos are often stored sideways with an EXIF orientation tag. The tag,
+// like all metadata, is not carried into saved thumbnails.
+func decodeImage(data []byte) (image.Image, error) {
+	kind, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if !decodableTypes[kind] {
+		return nil, &ImageError{Msg: fmt.Sprintf("Unsupported image type %s. Use a JPEG, PNG, GIF or WebP image.", kind)}
+	}
+
+	// The header gives the size before anything is allocated for it
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, &ImageError{Msg: fmt.Sprintf("Could not decode the %s image.", kind), Err: err}
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, &ImageError{Msg: fmt.Sprintf("The image is %d×%d; images over %d megapixels are not accepted.",
+			cfg.Width, cfg.Height, maxImagePixels/1_000_000)}
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, &ImageError{Msg: fmt.Sprintf("Could not decode the %s image.", kind), Err: err}
+	}
+	return img, nil
+}
+
+// flatten paints img over white, so transparent PNG, GIF and WebP areas
+// don't come out black in the JPEG thumbnail.
+func flatten(img image.Image) image.Image {
+	b := img.Bounds()
+	bg := imaging.New(b.Dx(), b.Dy(), color.White)
+	return imaging.Overlay(bg, img, image.Pt(0, 0), 1.0)
+}
+
+// createPlaceholder saves a square JPEG with the artist's initials on a
+// colour picked from the name, standing in until a real image arrives.
+func createPlaceholder(name, filename string) error {
+	if err := os.MkdirAll(imagesDir, 0755); err != nil {
+		return err
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	sum := h.Sum32()
+	bg := color.NRGBA{R: uint8(96 + sum%128), G: uint8(96 + (sum>>8)%128), B: uint8(96 + (sum>>16)%128), A: 255}
+
+	// Draw the initials small with the built-in bitmap font, then scale up
+	const size = 40
+	small := imaging.New(size, size, bg)
+	face := basicfont.Face7x13
+	text := initials(name)
+	d := &font.Drawer{
+		Dst:  small,
+		Src:  image.NewUniform(color.White),
+		Face: face,
+		Dot: fixed.P(
+			(size-face.Advance*len([]rune(text)))/2,
+			(size+face.Ascent-face.Descent)/2,
+		),
+	}
+	d.DrawString(text)
+
+	placeholder := imaging.Resize(small, thumbWidth, thumbWidth, imaging.NearestNeighbor)
+	if err := imaging.Save(placeholder, filepath.Join(imagesDir, filename)); err != nil {
+		return fmt.Errorf("error saving placeholder: %v", err)
+	}
+	return nil
+}
+
+// initials returns up to two capital initials of name, "?" if it has none.
+func initials(name string) string {
+	var out []rune
+	for _, word := range strings.Fields(name) {
+		for _, r := range word {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				out = append(out, unicode.ToUpper(r))
+				break
+			}
+		}
+		if len(out) == 2 {
+			break
+		}
+	}
+	if len(out) == 0 {
+		return "?"
+	}
+	return string(out)
+}
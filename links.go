@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// linkCheckWorkers bounds how many image hosts are asked at once.
+const linkCheckWorkers = 4
+
+// LinkCheck is the outcome of the last check of an artist's ImgURL.
+type LinkCheck struct {
+	Checked time.Time // zero if never checked
+	Msg     string    // why the link is broken, "" if it still serves an image
+}
+
+func (c LinkCheck) Broken() bool { return !c.Checked.IsZero() && c.Msg != "" }
+
+// linkCheckLast is the run started from the admin page, guarded by batchMu.
+var linkCheckLast *BatchReport
+
+// ImageLink is an image URL to check, an artist's cover or one of its
+// further images, with its last check.
+type ImageLink struct {
+	ID      int // the artist's
+	Name    string
+	ImageID int // 0 for the cover
+	ImgURL  string
+	Link    LinkCheck
+}
+
+// brokenLinks lists the image URLs that failed their last check, covers
+// first within each artist. Call with storeMu held.
+func brokenLinks() []ImageLink {
+	var broken []ImageLink
+	for _, rec := range globalMasterList {
+		if rec.Link.Broken() && fetchableURL(rec.ImgURL) {
+			broken = append(broken, ImageLink{ID: rec.ID, Name: rec.Name, ImgURL: rec.ImgURL, Link: rec.Link})
+		}
+		for _, img := range rec.Images {
+			if img.Link.Broken() && fetchableURL(img.ImgURL) {
+				broken = append(broken, ImageLink{ID: rec.ID, Name: rec.Name, ImageID: img.ID, ImgURL: img.ImgURL, Link: img.Link})
+			}
+		}
+	}
+	return broken
+}
+
+// checkLink asks whether imageURL still serves an image, with a HEAD
+// request, or a GET for hosts that refuse HEAD. It returns "" if so, and
+// otherwise the reason in the words the add form would use.
+func checkLink(ctx context.Context, imageURL string) string {
+	resp, err := linkRequest(ctx, http.MethodHead, imageURL)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed ||
+		resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		resp, err = linkRequest(ctx, http.MethodGet, imageURL)
+	}
+	if err != nil {
+		return imageErrorMsg(err)
+	}
+	// Only the headers matter; closing unread drops a GET's body
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Sprintf("The image host answered %s.", resp.Status)
+	}
+	if err := checkImageContentType(resp); err != nil {
+		return imageErrorMsg(err)
+	}
+	return ""
+}
+
+func linkRequest(ctx context.Context, method, imageURL string) (*http.Response, error) {
+	req, err := newImageRequest(ctx, method, imageURL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return nil, fetchError(err)
+	}
+	return resp, nil
+}
+
+// checkAllLinks checks every image URL there is to fetch, covers and
+// further images alike, recording each result on its image unless the
+// URL was edited or the image removed meanwhile, then saves the master
+// list. Broken links are listed as the report's failures.
+func checkAllLinks(report *BatchReport) {
+	storeMu.Lock()
+	var links []ImageLink
+	for _, rec := range globalMasterList {
+		if fetchableURL(rec.ImgURL) {
+			links = append(links, ImageLink{ID: rec.ID, Name: rec.Name, ImgURL: rec.ImgURL})
+		}
+		for _, img := range rec.Images {
+			if fetchableURL(img.ImgURL) {
+				links = append(links, ImageLink{ID: rec.ID, Name: rec.Name, ImageID: img.ID, ImgURL: img.ImgURL})
+			}
+		}
+	}
+	storeMu.Unlock()
+
+	batchMu.Lock()
+	report.Total = len(links)
+	batchMu.Unlock()
+
+	jobs := make(chan ImageLink)
+	var wg sync.WaitGroup
+	for w := 0; w < linkCheckWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for link := range jobs {
+				ctx, cancel := context.WithTimeout(context.Background(), fetchTotalTimeout)
+				msg := checkLink(ctx, link.ImgURL)
+				cancel()
+
+				storeMu.Lock()
+				recordLinkCheck(link, LinkCheck{Checked: time.Now(), Msg: msg})
+				storeMu.Unlock()
+
+				batchMu.Lock()
+				report.Done++
+				if msg != "" {
+					if link.ImageID != 0 {
+						msg = fmt.Sprintf("Image %d: %s", link.ImageID, msg)
+					}
+					report.Failures = append(report.Failures, BatchFailure{ID: link.ID, Name: link.Name, Msg: msg})
+				} else {
+					report.Succeeded++
+				}
+				batchMu.Unlock()
+			}
+		}()
+	}
+	for _, link := range links {
+		jobs <- link
+	}
+	close(jobs)
+	wg.Wait()
+
+	storeMu.Lock()
+	if err := saveMasterListInternal(); err != nil {
+		log.Printf("check-links: saving master list: %v", err)
+	}
+	storeMu.Unlock()
+
+	batchMu.Lock()
+	report.Running, report.Finished = false, time.Now()
+	batchMu.Unlock()
+}
+
+// recordLinkCheck saves the outcome of checking link on its image, unless
+// that has since been removed or given another URL. Call with storeMu held.
+func recordLinkCheck(link ImageLink, check LinkCheck) {
+	i := findArtistIndex(link.ID)
+	if i == -1 {
+		return
+	}
+	rec := &globalMasterList[i]
+	if link.ImageID == 0 {
+		if rec.ImgURL == link.ImgURL {
+			rec.Link = check
+		}
+		return
+	}
+	if j := findImageIndex(rec, link.ImageID); j != -1 && rec.Images[j].ImgURL == link.ImgURL {
+		rec.Images[j].Link = check
+	}
+}
+
+// htmx handler: start checking every image link in the background, unless
+// a check is already going, and show its progress
+func checkLinksStartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	batchMu.Lock()
+	if linkCheckLast == nil || !linkCheckLast.Running {
+		linkCheckLast = &BatchReport{Started: time.Now(), Running: true}
+		// storeMu is held by this handler; the run waits its turn for it
+		go checkAllLinks(linkCheckLast)
+	}
+	batchMu.Unlock()
+	checkLinksStatusHandler(w, r)
+}
+
+// htmx handler: progress or outcome of the last link check, which polls
+// itself while the check is going
+func checkLinksStatusHandler(w http.ResponseWriter, r *http.Request) {
+	err := templates.ExecuteTemplate(w, "link_check_status", snapshotReport(linkCheckLast))
+	if err != nil {
+		http.Error(w, "Template error: "+err.Error(), 500)
+	}
+}
+
+// Report page: image URLs that failed their last check, each with a
+// shortcut to its artist's edit form
+func brokenLinksPage(w http.ResponseWriter, r *http.Request) {
+	err := templates.ExecuteTemplate(w, "broken_links_page", brokenLinks())
+	if err != nil {
+		http.Error(w, "Template error: "+err.Error(), 500)
+	}
+}
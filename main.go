@@ -2,19 +2,16 @@ package main
 
 import (
 	// "bufio"
-	"bytes"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/disintegration/imaging"
 )
 
 type ArtistRecord struct {
@@ -23,17 +20,34 @@ type ArtistRecord struct {
 	Description string
 	ImgURL      string
 	Thumb       string
+	ThumbWidths []int  // extra sizes saved beside Thumb, see thumbSizeName
+	Original    string // archived full-size image in originalsDir, if any
+	Crop        Crop   // thumbnail framing, a centred fit by default
+	Link        LinkCheck
+	ImageHash   uint64        // imageHash of the image, if Hashed
+	Hashed      bool          // a flat image hashes to 0, so ImageHash alone can't tell
+	Palette     Palette       // dominant colours of the thumbnail, nil if not found yet
+	Images      []ArtistImage // further images after this cover, in carousel order
+	LastImageID int           // highest ArtistImage.ID given out, never reused
+
+	ThumbStatus   string // thumbPending or thumbNeedsImage while Thumb is not the real image
+	ThumbMsg      string // why the last thumbnail job failed
+	ThumbAttempts int    // failed attempts at the current ImgURL
 }
 
 type FormData struct {
-	Name         string
-	OriginalName string
-	Desc         string
-	ImgURL       string
+	Name   string
+	TodoID int // to-do entry the form was populated from, 0 if typed in
+	Desc   string
+	ImgURL string
+
+	AllowPlaceholder bool // save without a working image, see thumbNeedsImage
 
 	NameMsg string
 	DescMsg string
 	ImgMsg  string
+
+	Status *ArtistRecord // just-added artist whose thumbnail is being fetched
 }
 
 type EditFormData struct {
@@ -43,8 +57,16 @@ type EditFormData struct {
 	ImgMsg  string
 }
 
+// CropModes lists the choices for the edit form's crop menu.
+func (EditFormData) CropModes() []CropMode { return cropModes }
+
+// ImagesData is the edit form's image list, before any change.
+func (d EditFormData) ImagesData() ArtistImagesData {
+	return ArtistImagesData{ArtistRecord: d.ArtistRecord}
+}
+
 type AddArtistPageData struct {
-	ToAdd    []string
+	ToAdd    TodoList
 	FormData FormData
 }
 
@@ -52,7 +74,11 @@ var templates *template.Template
 
 // File-backed data
 var globalMasterList []ArtistRecord
-var globalToAddList []string
+var globalToAddList []TodoEntry
+
+// storeMu guards both lists; handlers hold it via locked(), thumbnail
+// workers take it when they record a result.
+var storeMu sync.Mutex
 
 var dataDir = "data"     // Default prod
 var imagesDir = "images" // Default prod
@@ -83,6 +109,45 @@ func ReadMasterList(filename string) ([]ArtistRecord, error) {
 				rec.ImgURL = strings.TrimSpace(line[2:])
 			} else if strings.HasPrefix(line, "t:") {
 				rec.Thumb = strings.TrimSpace(line[2:])
+			} else if strings.HasPrefix(line, "w:") {
+				for _, w := range strings.Split(strings.TrimSpace(line[2:]), ",") {
+					if n, err := strconv.Atoi(w); err == nil {
+						rec.ThumbWidths = append(rec.ThumbWidths, n)
+					}
+				}
+			} else if strings.HasPrefix(line, "o:") {
+				rec.Original = strings.TrimSpace(line[2:])
+			} else if strings.HasPrefix(line, "c:") {
+				rec.Crop.Mode = strings.TrimSpace(line[2:])
+			} else if strings.HasPrefix(line, "f:") {
+				fmt.Sscanf(strings.TrimSpace(line[2:]), "%g,%g", &rec.Crop.FocusX, &rec.Crop.FocusY)
+			} else if strings.HasPrefix(line, "ts:") {
+				rec.ThumbStatus = strings.TrimSpace(line[3:])
+				if rec.ThumbStatus == "failed" { // written before needs-image existed
+					rec.ThumbStatus = thumbNeedsImage
+				}
+			} else if strings.HasPrefix(line, "tm:") {
+				rec.ThumbMsg = strings.TrimSpace(line[3:])
+			} else if strings.HasPrefix(line, "ta:") {
+				rec.ThumbAttempts, _ = strconv.Atoi(strings.TrimSpace(line[3:]))
+			} else if strings.HasPrefix(line, "ph:") {
+				rec.ImageHash, err = strconv.ParseUint(strings.TrimSpace(line[3:]), 16, 64)
+				rec.Hashed = err == nil
+			} else if strings.HasPrefix(line, "pl:") {
+				rec.Palette = ParsePalette(line[3:])
+			} else if strings.HasPrefix(line, "xl:") {
+				rec.LastImageID, _ = strconv.Atoi(strings.TrimSpace(line[3:]))
+			} else if strings.HasPrefix(line, "xi:") {
+				// Further images: "xi:" starts one, the other "x" keys fill it in
+				id, _ := strconv.Atoi(strings.TrimSpace(line[3:]))
+				rec.Images = append(rec.Images, ArtistImage{ID: id})
+				rec.LastImageID = max(rec.LastImageID, id) // files saved before "xl:"
+			} else if strings.HasPrefix(line, "x") && len(rec.Images) > 0 {
+				readImageLine(&rec.Images[len(rec.Images)-1], line)
+			} else if strings.HasPrefix(line, "lc:") {
+				rec.Link.Checked, _ = time.Parse(time.RFC3339, strings.TrimSpace(line[3:]))
+			} else if strings.HasPrefix(line, "lm:") {
+				rec.Link.Msg = strings.TrimSpace(line[3:])
 			}
 		}
 		records = append(records, rec)
@@ -90,27 +155,78 @@ func ReadMasterList(filename string) ([]ArtistRecord, error) {
 	return records, nil
 }
 
-func ReadToAddList(filename string) ([]string, error) {
+// ReadToAddList reads the to-do file. Entries are written as blocks like
+// the master list ("id:" and "n:" lines, plus optional "note:", "src:",
+// "date:", "p:" and "skip:"); a bare line is a name appended by hand while the app was
+// stopped, and gets an ID here. A leading "last:" line holds the highest ID
+// ever given out, which is returned too, so a deleted entry's ID is never
+// reused.
+func ReadToAddList(filename string) ([]TodoEntry, int, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	lastID := 0
+	var entries []TodoEntry
+	var cur TodoEntry
+	flush := func() {
+		if cur.Name != "" {
+			entries = append(entries, cur)
+		}
+		cur = TodoEntry{}
 	}
-	var names []string
 	lines := strings.Split(string(data), "\n")
 	for _, line := range lines {
-		name := strings.TrimSpace(line)
-		if name != "" {
-			names = append(names, name)
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "last:"):
+			lastID, _ = strconv.Atoi(strings.TrimSpace(line[5:]))
+		case strings.HasPrefix(line, "id:"):
+			if cur.ID != 0 || cur.Name != "" {
+				flush()
+			}
+			cur.ID, _ = strconv.Atoi(strings.TrimSpace(line[3:]))
+		case strings.HasPrefix(line, "n:"):
+			cur.Name = strings.TrimSpace(line[2:])
+		case strings.HasPrefix(line, "note:"):
+			cur.Note = strings.TrimSpace(line[5:])
+		case strings.HasPrefix(line, "src:"):
+			cur.Source = strings.TrimSpace(line[4:])
+		case strings.HasPrefix(line, "date:"):
+			cur.Added = strings.TrimSpace(line[5:])
+		case strings.HasPrefix(line, "p:"):
+			cur.Priority = strings.TrimSpace(line[2:]) == "1"
+		case strings.HasPrefix(line, "skip:"):
+			cur.Skip = strings.TrimSpace(line[5:]) == "1"
+		default:
+			flush()
+			entries = append(entries, TodoEntry{Name: line})
 		}
 	}
-	return names, nil
+	flush()
+
+	// Hand-added names, and any IDs clashing after a manual edit, get fresh ones
+	for _, e := range entries {
+		lastID = max(lastID, e.ID)
+	}
+	seen := make(map[int]bool, len(entries))
+	for i := range entries {
+		if entries[i].ID == 0 || seen[entries[i].ID] {
+			lastID++
+			entries[i].ID = lastID
+		}
+		seen[entries[i].ID] = true
+	}
+	return entries, lastID, nil
 }
 
 // --- Handlers ---
 
 func addArtistPage(w http.ResponseWriter, r *http.Request) {
 	data := AddArtistPageData{
-		ToAdd:    globalToAddList,
+		ToAdd:    todoListView(r),
 		FormData: FormData{},
 	}
 
@@ -122,9 +238,57 @@ func addArtistPage(w http.ResponseWriter, r *http.Request) {
 }
 
 func galleryPage(w http.ResponseWriter, r *http.Request) {
+	// ?filter=needs-image lists only artists still waiting on a real image
+	filter := r.FormValue("filter")
+	artists := globalMasterList
+	needsImage := 0
+	for _, rec := range globalMasterList {
+		if rec.ThumbStatus == thumbNeedsImage {
+			needsImage++
+		}
+	}
+	if filter == thumbNeedsImage {
+		artists = nil
+		for _, rec := range globalMasterList {
+			if rec.ThumbStatus == thumbNeedsImage {
+				artists = append(artists, rec)
+			}
+		}
+	}
+
+	// ?colour=warm keeps artists whose palette matches, ?sort=hue orders
+	// them by colour; see colourFilters and colourSorts
+	colour, order := r.FormValue("colour"), r.FormValue("sort")
+	artists = sortByColour(filterByColour(artists, colour), order)
+
+	// ?select=3,17 preselects artists in the working set (see prompt import)
+	preselect := []*ArtistDetail{}
+	for _, s := range strings.Split(r.FormValue("select"), ",") {
+		id, _ := strconv.Atoi(strings.TrimSpace(s))
+		for _, rec := range globalMasterList {
+			if rec.ID == id {
+				preselect = append(preselect, artistDetail(rec))
+			}
+		}
+	}
+
+	// ?edit=id opens that artist's edit form, e.g. from the broken links report
+	edit, _ := strconv.Atoi(r.FormValue("edit"))
+
 	data := struct {
-		Artists []ArtistRecord
-	}{Artists: globalMasterList}
+		Artists       []ArtistRecord
+		Preselect     []*ArtistDetail
+		Filter        string
+		NeedsImage    int
+		Edit          int
+		Colour        string
+		Sort          string
+		ColourFilters []ColourFilter
+		ColourSorts   []ColourSort
+	}{
+		Artists: artists, Preselect: preselect, Filter: filter, NeedsImage: needsImage, Edit: edit,
+		Colour: colour, Sort: order, ColourFilters: colourFilters, ColourSorts: colourSorts,
+	}
 
 	err := templates.ExecuteTemplate(w, "gallery_page", data)
 	if err != nil {
@@ -132,14 +296,19 @@ func galleryPage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// htmx handler: populate form with selected name
+// htmx handler: populate form with selected to-do entry
 func populateFormHandler(w http.ResponseWriter, r *http.Request) {
-	name := r.FormValue("name")
+	entry, ok := findTodo(todoIDFromRequest(r, "id"))
+	if !ok {
+		http.Error(w, "To-do entry not found", 404)
+		return
+	}
 	data := AddArtistPageData{
 		FormData: FormData{
-			Name:         name,
-			OriginalName: name,
-			NameMsg:      "",
+			Name:    entry.Name,
+			TodoID:  entry.ID,
+			Desc:    entry.FormDesc(),
+			NameMsg: "",
 		},
 	}
 	// Only render the form partial
@@ -152,7 +321,7 @@ func populateFormHandler(w http.ResponseWriter, r *http.Request) {
 // htmx handler: check for duplicates and update the whole form
 func checkNameHandler(w http.ResponseWriter, r *http.Request) {
 	name := strings.TrimSpace(r.FormValue("name")) // <- trim spaces
-	originalName := r.FormValue("original_name")
+	todoID := todoIDFromRequest(r, "todo_id")
 	msg := ""
 	// Search master list for duplicate (case-insensitive)
 	for _, rec := range globalMasterList {
@@ -164,9 +333,9 @@ func checkNameHandler(w http.ResponseWriter, r *http.Request) {
 
 	data := AddArtistPageData{
 		FormData: FormData{
-			Name:         name,
-			OriginalName: originalName,
-			NameMsg:      msg,
+			Name:    name,
+			TodoID:  todoID,
+			NameMsg: msg,
 		},
 	}
 	// Only render the form partial
@@ -188,11 +357,12 @@ func cancelAddFormHandler(w http.ResponseWriter, r *http.Request) {
 
 // htmx handler: show confirmation dialog for deleting from to-do list
 func confirmDeleteTodoHandler(w http.ResponseWriter, r *http.Request) {
-	name := r.FormValue("name")
-	data := struct {
-		Name string
-	}{Name: name}
-	err := templates.ExecuteTemplate(w, "confirm_delete_content", data)
+	entry, ok := findTodo(todoIDFromRequest(r, "id"))
+	if !ok {
+		http.Error(w, "To-do entry not found", 404)
+		return
+	}
+	err := templates.ExecuteTemplate(w, "confirm_delete_content", entry)
 	if err != nil {
 		http.Error(w, "Template error: "+err.Error(), 500)
 	}
@@ -200,133 +370,153 @@ func confirmDeleteTodoHandler(w http.ResponseWriter, r *http.Request) {
 
 // htmx handler: actually delete from to-do list and return updated list items
 func deleteTodoItemHandler(w http.ResponseWriter, r *http.Request) {
-	nameToDelete := strings.TrimSpace(r.FormValue("name"))
-	if nameToDelete == "" {
-		http.Error(w, "Name is required", 400)
+	id := todoIDFromRequest(r, "id")
+	if id == 0 {
+		http.Error(w, "ID is required", 400)
 		return
 	}
 
-	// Remove name from to-do list
-	newList := make([]string, 0, len(globalToAddList))
-	for _, name := range globalToAddList {
-		if !strings.EqualFold(name, nameToDelete) {
-			newList = append(newList, name)
+	// Remove entry from to-do list and save
+	if removeTodo(id) {
+		if err := saveToAddListInternal(); err != nil {
+			http.Error(w, "Failed to save to-do list", 500)
+			return
 		}
 	}
-	globalToAddList = newList
-
-	// Save list
-	err := os.WriteFile(filepath.Join(dataDir, "artists_to_add.txt"), []byte(strings.Join(globalToAddList, "\n")+"\n"), 0644)
-	if err != nil {
-		http.Error(w, "Failed to save to-do list", 500)
-		return
-	}
 
 	// Return updated list items (inner HTML of <ul>)
 	data := AddArtistPageData{
-		ToAdd: globalToAddList,
+		ToAdd: todoListView(r),
 	}
-	err = templates.ExecuteTemplate(w, "todo_list_items", data)
+	err := templates.ExecuteTemplate(w, "todo_list_items", data)
 	if err != nil {
 		http.Error(w, "Template error: "+err.Error(), 500)
 	}
 }
 
-// htmx handler: add one or more names to the to-do list
+// htmx handler: add one or more names to the to-do list, skipping names
+// already queued (including repeats within the pasted text)
 func addToTodoListHandler(w http.ResponseWriter, r *http.Request) {
 	rawNames := r.FormValue("names")
 	lines := strings.Split(rawNames, "\n")
 	updated := false
 
+	today := time.Now().Format("2006-01-02")
+	queued := make(map[string]bool, len(globalToAddList))
+	for _, e := range globalToAddList {
+		queued[strings.ToLower(e.Name)] = true
+	}
+
 	for _, line := range lines {
 		name := strings.TrimSpace(line)
-		if name != "" {
-			globalToAddList = append(globalToAddList, name)
+		if name != "" && !queued[strings.ToLower(name)] {
+			globalToAddList = append(globalToAddList, TodoEntry{ID: nextTodoID(), Name: name, Added: today})
+			queued[strings.ToLower(name)] = true
 			updated = true
 		}
 	}
 
 	if updated {
-		_ = os.WriteFile(filepath.Join(dataDir, "artists_to_add.txt"), []byte(strings.Join(globalToAddList, "\n")+"\n"), 0644)
+		_ = saveToAddListInternal()
 	}
 
 	data := AddArtistPageData{
-		ToAdd: globalToAddList,
+		ToAdd: todoListView(r),
 	}
 	_ = templates.ExecuteTemplate(w, "todo_list_items", data)
 }
 
+// htmx handler: drop to-do entries already in the gallery and all but the
+// first spelling of possible duplicates
+func cleanupTodoHandler(w http.ResponseWriter, r *http.Request) {
+	inMaster := masterIDsByName()
+	seen := make(map[string]bool, len(globalToAddList))
+
+	newList := make([]TodoEntry, 0, len(globalToAddList))
+	for _, e := range globalToAddList {
+		key := normalizeName(e.Name)
+		if inMaster[key] != 0 || seen[key] {
+			continue
+		}
+		seen[key] = true
+		newList = append(newList, e)
+	}
+
+	if len(newList) != len(globalToAddList) {
+		globalToAddList = newList
+		if err := saveToAddListInternal(); err != nil {
+			http.Error(w, "Failed to save to-do list", 500)
+			return
+		}
+	}
+
+	data := AddArtistPageData{
+		ToAdd: todoListView(r),
+	}
+	err := templates.ExecuteTemplate(w, "todo_list_items", data)
+	if err != nil {
+		http.Error(w, "Template error: "+err.Error(), 500)
+	}
+}
+
 // htmx handler: decide whether to show confirmation dialog or delete directly
 func confirmDeleteTodoFormHandler(w http.ResponseWriter, r *http.Request) {
-	originalName := strings.TrimSpace(r.FormValue("original_name"))
-
-	if originalName == "" {
-		// No name to delete, just clear form by calling deleteTodoFormHandler directly
+	entry, ok := findTodo(todoIDFromRequest(r, "todo_id"))
+	if !ok {
+		// No to-do entry behind the form, just clear form by calling deleteTodoFormHandler directly
 		deleteTodoFormHandler(w, r)
 		return
 	}
 
-	// Name exists → show confirmation dialog
-	data := struct {
-		Name string
-	}{
-		Name: originalName,
-	}
-
-	// err := templates.ExecuteTemplate(w, "confirm_delete_content", data)
-	err := templates.ExecuteTemplate(w, "confirm_delete_and_clear_content", data)
+	// Entry exists → show confirmation dialog
+	err := templates.ExecuteTemplate(w, "confirm_delete_and_clear_content", entry)
 	if err != nil {
 		http.Error(w, "Template error: "+err.Error(), 500)
 		return
 	}
 }
 
-// htmx handler: delete name from to-do list based on original name inside form
+// htmx handler: delete the to-do entry the form was populated from
 func deleteTodoFormHandler(w http.ResponseWriter, r *http.Request) {
-	originalName := strings.TrimSpace(r.FormValue("original_name"))
-	if originalName == "" {
-		// If called with no original_name (e.g. user typed name manually), we still return response
+	id := todoIDFromRequest(r, "todo_id")
+	if id == 0 {
+		// If called with no todo_id (e.g. user typed name manually), we still return response
 		data := AddArtistPageData{
-			ToAdd:    globalToAddList, // unchanged
+			ToAdd:    todoListView(r), // unchanged
 			FormData: FormData{},      // blank form to clear
 		}
 		_ = templates.ExecuteTemplate(w, "submit_response", data)
 		return
 	}
 
-	// Remove name from to-do list
-	newList := make([]string, 0, len(globalToAddList))
-	for _, name := range globalToAddList {
-		if !strings.EqualFold(name, originalName) {
-			newList = append(newList, name)
+	// Remove entry from to-do list and save
+	if removeTodo(id) {
+		if err := saveToAddListInternal(); err != nil {
+			http.Error(w, "Failed to save to-do list", 500)
+			return
 		}
 	}
-	globalToAddList = newList
-
-	// Save list
-	err := os.WriteFile(filepath.Join(dataDir, "artists_to_add.txt"), []byte(strings.Join(globalToAddList, "\n")+"\n"), 0644)
-
-	if err != nil {
-		http.Error(w, "Failed to save to-do list", 500)
-		return
-	}
 
 	// ✅ return full form + list response via out-of-band swaps
 	data := AddArtistPageData{
-		ToAdd:    globalToAddList,
+		ToAdd:    todoListView(r),
 		FormData: FormData{}, // clear the form
 	}
-	err = templates.ExecuteTemplate(w, "submit_response", data)
+	err := templates.ExecuteTemplate(w, "submit_response", data)
 	if err != nil {
 		http.Error(w, "Template error: "+err.Error(), 500)
 	}
 }
 
+// Registered without locked(): an upload is thumbnailed before storeMu is
+// taken, so a big image doesn't hold up other requests
 func submitArtistAddFormHandler(w http.ResponseWriter, r *http.Request) {
+	uploadErr := parseUploadForm(w, r)
 	name := strings.TrimSpace(r.FormValue("name"))
-	originalName := strings.TrimSpace(r.FormValue("original_name"))
+	todoID := todoIDFromRequest(r, "todo_id")
 	desc := strings.TrimSpace(r.FormValue("desc"))
 	imgURL := strings.TrimSpace(r.FormValue("img_url"))
+	allowPlaceholder := r.FormValue("allow_placeholder") != ""
 
 	var nameMsg, descMsg, imgMsg string
 
@@ -337,8 +527,30 @@ func submitArtistAddFormHandler(w http.ResponseWriter, r *http.Request) {
 	if desc == "" {
 		descMsg = "Description is required."
 	}
-	if imgURL == "" {
-		imgMsg = "Image URL is required."
+
+	// An uploaded or pasted file wins over the URL field
+	var upload []byte
+	if uploadErr == nil {
+		upload, uploadErr = formImage(r)
+	}
+	if isDataURL(imgURL) {
+		imgURL = "" // decoded above; don't echo it back into the form
+	}
+	// Thumbnail an upload before taking the lock, unless the form is
+	// already bound to come back
+	var prepared *preparedUpload
+	if upload != nil && name != "" && desc != "" {
+		prepared, uploadErr = prepareUpload(upload, Crop{})
+	}
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	switch {
+	case uploadErr != nil:
+		imgMsg = imageErrorMsg(uploadErr)
+	case upload == nil && imgURL == "" && !allowPlaceholder:
+		imgMsg = "Give an image URL or file, or tick \"save with a placeholder\"."
 	}
 
 	// Check for duplicate in master list
@@ -352,16 +564,18 @@ func submitArtistAddFormHandler(w http.ResponseWriter, r *http.Request) {
 
 	// If any validation failed, return form with all values preserved
 	if nameMsg != "" || descMsg != "" || imgMsg != "" {
+		prepared.discard()
 		data := AddArtistPageData{
-			ToAdd: globalToAddList,
+			ToAdd: todoListView(r),
 			FormData: FormData{
-				Name:         name,
-				OriginalName: originalName,
-				Desc:         desc,
-				ImgURL:       imgURL,
-				NameMsg:      nameMsg,
-				DescMsg:      descMsg,
-				ImgMsg:       imgMsg,
+				Name:             name,
+				TodoID:           todoID,
+				Desc:             desc,
+				ImgURL:           imgURL,
+				AllowPlaceholder: allowPlaceholder,
+				NameMsg:          nameMsg,
+				DescMsg:          descMsg,
+				ImgMsg:           imgMsg,
 			},
 		}
 		_ = templates.ExecuteTemplate(w, "submit_response", data)
@@ -376,69 +590,47 @@ func submitArtistAddFormHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	newID := maxID + 1
-	thumbFile := fmt.Sprintf("%d-%d.jpg", newID, time.Now().Unix())
-
-	// Try to create thumbnail
-	if !thumbnailExists(thumbFile) {
-		if err := fetchAndCreateThumbnail(imgURL, thumbFile); err != nil {
-			log.Printf("thumbnail error for %s: %v", imgURL, err)
-			imgMsg = "Warning: could not create thumbnail from image URL."
-			data := AddArtistPageData{
-				ToAdd: globalToAddList,
-				FormData: FormData{
-					Name:         name,
-					OriginalName: originalName,
-					Desc:         desc,
-					ImgURL:       imgURL,
-					NameMsg:      nameMsg,
-					DescMsg:      descMsg,
-					ImgMsg:       imgMsg,
-				},
-			}
-			_ = templates.ExecuteTemplate(w, "submit_response", data)
-			return // stop processing further
-		}
-	}
 
-	// Add artist to master list
+	// Add artist to master list straight away; the thumbnail follows from
+	// the job queue and the form polls for it. With no URL yet the artist
+	// gets a placeholder and shows up under "needs image".
 	newRec := ArtistRecord{
 		ID:          newID,
 		Name:        name,
 		Description: desc,
 		ImgURL:      imgURL,
-		Thumb:       thumbFile,
+	}
+	if prepared != nil {
+		useUploadedImage(&newRec, prepared)
+	} else if imgURL != "" {
+		queueThumbnail(&newRec)
+	} else {
+		newRec.ThumbStatus, newRec.ThumbMsg = thumbNeedsImage, "No image URL yet."
+		ensurePlaceholder(&newRec)
 	}
 	globalMasterList = append(globalMasterList, newRec)
 
 	// Save master list to disk
-	var builder strings.Builder
-	for _, rec := range globalMasterList {
-		builder.WriteString(fmt.Sprintf("id:%d\nn:%s\nd:%s\ni:%s\nt:%s\n\n", rec.ID, rec.Name, rec.Description, rec.ImgURL, rec.Thumb))
-	}
-	if err := os.WriteFile(filepath.Join(dataDir, "artists_master.txt"), []byte(builder.String()), 0644); err != nil {
+	if err := saveMasterListInternal(); err != nil {
 		http.Error(w, "Error writing master list: "+err.Error(), 500)
 		return
 	}
 
-	// Remove name from to-do list if present
-	if originalName != "" {
-		newList := make([]string, 0, len(globalToAddList))
-		for _, n := range globalToAddList {
-			if !strings.EqualFold(n, originalName) {
-				newList = append(newList, n)
-			}
-		}
-		globalToAddList = newList
-		if err := os.WriteFile(filepath.Join(dataDir, "artists_to_add.txt"), []byte(strings.Join(globalToAddList, "\n")+"\n"), 0644); err != nil {
+	publishArtist("artist-added", newRec)
+	setHXTrigger(w, "artist-added", artistDetail(newRec))
+
+	// Remove the to-do entry the form was populated from, if any
+	if todoID != 0 && removeTodo(todoID) {
+		if err := saveToAddListInternal(); err != nil {
 			http.Error(w, "Error writing to-do list: "+err.Error(), 500)
 			return
 		}
 	}
 
-	// Return updated form (cleared) + updated list via OOB swaps
+	// Return updated form (cleared, with thumbnail progress) + updated list via OOB swaps
 	data := AddArtistPageData{
-		ToAdd:    globalToAddList,
-		FormData: FormData{}, // form cleared on success
+		ToAdd:    todoListView(r),
+		FormData: FormData{Status: &newRec}, // form cleared on success
 	}
 	_ = templates.ExecuteTemplate(w, "submit_response", data)
 }
@@ -449,12 +641,17 @@ func deleteArtistHandler(w http.ResponseWriter, r *http.Request) {
 
 	for i, rec := range globalMasterList {
 		if rec.ID == id {
-			// Delete the thumbnail file from disk
-			if rec.Thumb != "" {
-				_ = os.Remove(filepath.Join(imagesDir, rec.Thumb))
+			// Delete the thumbnail files from disk
+			removeThumbFiles(rec.Thumb, rec.ThumbWidths)
+			for _, img := range rec.Images {
+				removeThumbFiles(img.Thumb, img.ThumbWidths)
 			}
-			// Remove the record
+			// Remove the record, then its originals unless shared
 			globalMasterList = append(globalMasterList[:i], globalMasterList[i+1:]...)
+			removeOriginal(rec.Original)
+			for _, img := range rec.Images {
+				removeOriginal(img.Original)
+			}
 			break
 		}
 	}
@@ -462,8 +659,11 @@ func deleteArtistHandler(w http.ResponseWriter, r *http.Request) {
 	// Save the updated master list
 	saveMasterListInternal()
 
+	// Tell open galleries to drop the card
+	broker.Publish(StoreEvent{Type: "artist-deleted", ID: id})
+
 	// Signal to the frontend that this specific artist was deleted
-	w.Header().Set("HX-Trigger", fmt.Sprintf(`{"artist-deleted": {"id": "%s"}}`, idStr))
+	setHXTrigger(w, "artist-deleted", StoreEvent{ID: id})
 
 	// Return 200 OK with empty body. hx-swap="outerHTML" will remove the element.
 	w.WriteHeader(http.StatusOK)
@@ -498,18 +698,43 @@ func editArtistHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Registered without locked(), like submitArtistAddFormHandler
 func updateArtistHandler(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/artists/update/")
 	id, _ := strconv.Atoi(idStr)
 
+	uploadErr := parseUploadForm(w, r)
 	name := strings.TrimSpace(r.FormValue("name"))
 	desc := strings.TrimSpace(r.FormValue("desc"))
 	imgURL := strings.TrimSpace(r.FormValue("img_url"))
+	var upload []byte
+	if uploadErr == nil {
+		upload, uploadErr = formImage(r)
+	}
+	if isDataURL(imgURL) {
+		imgURL = "" // decoded above; don't echo it back into the form
+	}
+	crop := cropFromForm(r)
+	// Build the new thumbnail, from an upload or for a changed crop,
+	// before taking the lock
+	var prepared, reframed *preparedUpload
+	if upload != nil && name != "" && desc != "" {
+		prepared, uploadErr = prepareUpload(upload, crop)
+	} else if upload == nil && uploadErr == nil && name != "" && desc != "" {
+		reframed = prepareReframe(id, imgURL, crop)
+	}
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	defer func() {
+		prepared.discard() // the artist is gone, or the form came back
+		reframed.discard()
+	}()
 
 	for i, rec := range globalMasterList {
 		if rec.ID == id {
 			// Validation
-			var nameMsg, descMsg string
+			var nameMsg, descMsg, imgMsg string
 			if name == "" {
 				nameMsg = "Name is required."
 			}
@@ -527,7 +752,11 @@ func updateArtistHandler(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 
-			if nameMsg != "" || descMsg != "" {
+			if uploadErr != nil {
+				imgMsg = imageErrorMsg(uploadErr)
+			}
+
+			if nameMsg != "" || descMsg != "" || imgMsg != "" {
 				w.Header().Set("HX-Retarget", "#edit-form-target")
 				w.Header().Set("HX-Reswap", "innerHTML")
 				data := EditFormData{
@@ -536,10 +765,14 @@ func updateArtistHandler(w http.ResponseWriter, r *http.Request) {
 						Name:        name,
 						Description: desc,
 						ImgURL:      imgURL,
-						Thumb:       rec.Thumb,
+						Thumb:       globalMasterList[i].Thumb,
+						Original:    globalMasterList[i].Original,
+						Crop:        crop,
+						Images:      globalMasterList[i].Images,
 					},
 					NameMsg: nameMsg,
 					DescMsg: descMsg,
+					ImgMsg:  imgMsg,
 				}
 				err := templates.ExecuteTemplate(w, "edit_form_content", data)
 				if err != nil {
@@ -548,43 +781,39 @@ func updateArtistHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			// If URL changed and is not empty, fetch new image
-			if imgURL != "" && imgURL != globalMasterList[i].ImgURL {
-				oldThumb := globalMasterList[i].Thumb
-				newThumb := fmt.Sprintf("%d-%d.jpg", id, time.Now().Unix())
-				if err := fetchAndCreateThumbnail(imgURL, newThumb); err != nil {
-					log.Printf("thumbnail error for %s: %v", imgURL, err)
-					w.Header().Set("HX-Retarget", "#edit-form-target")
-					w.Header().Set("HX-Reswap", "innerHTML")
-					data := EditFormData{
-						ArtistRecord: ArtistRecord{
-							ID:          id,
-							Name:        name,
-							Description: desc,
-							ImgURL:      imgURL,
-							Thumb:       rec.Thumb,
-						},
-						ImgMsg: "Warning: could not create thumbnail from image URL.",
-					}
-					_ = templates.ExecuteTemplate(w, "edit_form_content", data)
-					return
-				}
-				// Success
+			// If URL changed and is not empty, queue a new thumbnail; the
+			// old one stays up until the job replaces it
+			queued := false
+			globalMasterList[i].Crop = crop
+			if prepared != nil {
+				useUploadedImage(&globalMasterList[i], prepared)
+				prepared = nil
+			} else if upload == nil && imgURL != "" && imgURL != globalMasterList[i].ImgURL {
 				globalMasterList[i].ImgURL = imgURL
-				globalMasterList[i].Thumb = newThumb
-				// Cleanup old thumb from disk
-				if oldThumb != "" && oldThumb != newThumb {
-					_ = os.Remove(filepath.Join(imagesDir, oldThumb))
-				}
+				globalMasterList[i].Link = LinkCheck{}
+				globalMasterList[i].ThumbAttempts = 0
+				queueThumbnail(&globalMasterList[i])
+				queued = true
+			} else if upload == nil && crop != rec.Crop {
+				queued = reframeThumbnail(&globalMasterList[i], reframed)
+				reframed = nil
 			}
 
 			globalMasterList[i].Name = name
 			globalMasterList[i].Description = desc
 
 			saveMasterListInternal()
-
-			// Reset the edit form area to its default state via OOB swap
-			fmt.Fprint(w, `<div id="edit-form-target" hx-swap-oob="true"><p>Click "edit" on a card above to load its data here.</p></div>`)
+			publishArtist("artist-updated", globalMasterList[i])
+			setHXTrigger(w, "artist-updated", artistDetail(globalMasterList[i]))
+
+			// Reset the edit form area to its default state via OOB swap,
+			// with thumbnail progress if a new image is on its way, or any
+			// duplicate warning for an uploaded one
+			fmt.Fprint(w, `<div id="edit-form-target" hx-swap-oob="true"><p>Click "edit" on a card above to load its data here.</p>`)
+			if queued || upload != nil {
+				_ = templates.ExecuteTemplate(w, "thumb_status", globalMasterList[i])
+			}
+			fmt.Fprint(w, `</div>`)
 
 			// Return just the updated grid item fragment
 			err := templates.ExecuteTemplate(w, "grid_item", globalMasterList[i])
@@ -597,12 +826,72 @@ func updateArtistHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // Helper to avoid code duplication
-func saveMasterListInternal() {
+func saveMasterListInternal() error {
 	var builder strings.Builder
 	for _, rec := range globalMasterList {
-		builder.WriteString(fmt.Sprintf("id:%d\nn:%s\nd:%s\ni:%s\nt:%s\n\n", rec.ID, rec.Name, rec.Description, rec.ImgURL, rec.Thumb))
+		builder.WriteString(fmt.Sprintf("id:%d\nn:%s\nd:%s\ni:%s\nt:%s\n", rec.ID, rec.Name, rec.Description, rec.ImgURL, rec.Thumb))
+		if len(rec.ThumbWidths) > 0 {
+			builder.WriteString(fmt.Sprintf("w:%s\n", joinInts(rec.ThumbWidths)))
+		}
+		if rec.Original != "" {
+			builder.WriteString(fmt.Sprintf("o:%s\n", rec.Original))
+		}
+		if rec.Crop.Mode != "" {
+			builder.WriteString(fmt.Sprintf("c:%s\n", rec.Crop.Mode))
+		}
+		if rec.Crop.FocusX != 0 || rec.Crop.FocusY != 0 {
+			builder.WriteString(fmt.Sprintf("f:%.3f,%.3f\n", rec.Crop.FocusX, rec.Crop.FocusY))
+		}
+		if rec.Hashed {
+			builder.WriteString(fmt.Sprintf("ph:%016x\n", rec.ImageHash))
+		}
+		if len(rec.Palette) > 0 {
+			builder.WriteString(fmt.Sprintf("pl:%s\n", rec.Palette))
+		}
+		if !rec.Link.Checked.IsZero() {
+			builder.WriteString(fmt.Sprintf("lc:%s\nlm:%s\n", rec.Link.Checked.Format(time.RFC3339), rec.Link.Msg))
+		}
+		if rec.ThumbStatus != thumbDone {
+			builder.WriteString(fmt.Sprintf("ts:%s\ntm:%s\nta:%d\n", rec.ThumbStatus, rec.ThumbMsg, rec.ThumbAttempts))
+		}
+		if rec.LastImageID != 0 {
+			builder.WriteString(fmt.Sprintf("xl:%d\n", rec.LastImageID))
+		}
+		for _, img := range rec.Images {
+			writeImageLines(&builder, img)
+		}
+		builder.WriteString("\n")
+	}
+	return os.WriteFile(filepath.Join(dataDir, "artists_master.txt"), []byte(builder.String()), 0644)
+}
+
+// joinInts formats ns as "1,2,3".
+func joinInts(ns []int) string {
+	parts := make([]string, len(ns))
+	for i, n := range ns {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ",")
+}
+
+// findArtistIndex returns the master list index of the artist, or -1.
+func findArtistIndex(id int) int {
+	for i, rec := range globalMasterList {
+		if rec.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// locked serialises handlers that read or change the in-memory lists,
+// which thumbnail workers update in the background.
+func locked(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		h(w, r)
 	}
-	_ = os.WriteFile(filepath.Join(dataDir, "artists_master.txt"), []byte(builder.String()), 0644)
 }
 
 // --- Main ---
@@ -616,6 +905,7 @@ func main() {
 		"templates/submit_response.tmpl",
 		"templates/confirm_dialog.tmpl",
 		"templates/gallery.tmpl",
+		"templates/admin.tmpl",
 	))
 
 	// Read ENV vars FIRST
@@ -629,6 +919,25 @@ func main() {
 	// Log what we're using
 	log.Printf("Using data dir: %s, images dir: %s", dataDir, imagesDir)
 
+	// Image fetches can't reach local addresses unless listed here
+	if allow := os.Getenv("IMAGE_FETCH_ALLOW"); allow != "" {
+		prefixes, err := ParseFetchAllow(allow)
+		if err != nil {
+			log.Fatal("Error parsing IMAGE_FETCH_ALLOW:", err)
+		}
+		fetchPolicy.Allow = prefixes
+		log.Printf("Image fetches may also reach: %v", prefixes)
+	}
+
+	// Thumbnail widths for srcset, e.g. THUMB_WIDTHS=200,400,800
+	if widths := os.Getenv("THUMB_WIDTHS"); widths != "" {
+		extra, err := ParseThumbWidths(widths)
+		if err != nil {
+			log.Fatal("Error parsing THUMB_WIDTHS:", err)
+		}
+		thumbExtraWidths = extra
+	}
+
 	// return
 
 	// Load lists using NEW paths
@@ -637,11 +946,28 @@ func main() {
 	if err != nil {
 		log.Fatal("Error reading master list:", err)
 	}
-	globalToAddList, err = ReadToAddList(filepath.Join(dataDir, "artists_to_add.txt"))
+	globalToAddList, lastTodoID, err = ReadToAddList(filepath.Join(dataDir, "artists_to_add.txt"))
 	if err != nil {
 		log.Fatal("Error reading to-add list:", err)
 	}
 
+	// Maintenance commands run instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	// Thumbnails are fetched in the background; pick up any a restart cut short
+	thumbQueue = NewThumbQueue(thumbWorkers, thumbQueueSize)
+	storeMu.Lock()
+	requeuePendingThumbnails()
+	// Artists saved before image hashes and palettes were kept
+	if backfillImageDetails() {
+		if err := saveMasterListInternal(); err != nil {
+			log.Printf("saving image details: %v", err)
+		}
+	}
+	storeMu.Unlock()
+
 	// // Load lists from files
 	// var err error
 	// globalMasterList, err = ReadMasterList("data/artists_master.txt")
@@ -653,20 +979,43 @@ func main() {
 	// 	log.Fatal("Error reading to-add list:", err)
 	// }
 
-	http.HandleFunc("/", addArtistPage)
-	http.HandleFunc("/gallery", galleryPage)
-	http.HandleFunc("/populate-form", populateFormHandler)
-	http.HandleFunc("/check-name", checkNameHandler)
-	http.HandleFunc("/delete-todo-form", deleteTodoFormHandler) // we may still call this with htmxx but from are you sure dialog
-	http.HandleFunc("/confirm-delete-todo-form", confirmDeleteTodoFormHandler)
-	http.HandleFunc("/cancel-add-form", cancelAddFormHandler)
+	http.HandleFunc("/", locked(addArtistPage))
+	http.HandleFunc("/gallery", locked(galleryPage))
+	http.HandleFunc("/populate-form", locked(populateFormHandler))
+	http.HandleFunc("/check-name", locked(checkNameHandler))
+	http.HandleFunc("/delete-todo-form", locked(deleteTodoFormHandler)) // we may still call this with htmxx but from are you sure dialog
+	http.HandleFunc("/confirm-delete-todo-form", locked(confirmDeleteTodoFormHandler))
+	http.HandleFunc("/cancel-add-form", locked(cancelAddFormHandler))
 	http.HandleFunc("/submit-artist-add-form", submitArtistAddFormHandler)
-	http.HandleFunc("/confirm-delete-todo", confirmDeleteTodoHandler)
-	http.HandleFunc("/delete-todo-item", deleteTodoItemHandler)
-	http.HandleFunc("/add-to-todo-list", addToTodoListHandler)
-	http.HandleFunc("/artists/delete/", deleteArtistHandler)
-	http.HandleFunc("/artists/edit/", editArtistHandler)
+	http.HandleFunc("/confirm-delete-todo", locked(confirmDeleteTodoHandler))
+	http.HandleFunc("/delete-todo-item", locked(deleteTodoItemHandler))
+	http.HandleFunc("/add-to-todo-list", locked(addToTodoListHandler))
+	http.HandleFunc("/cleanup-todo", locked(cleanupTodoHandler))
+	http.HandleFunc("/import-prompt", locked(importPromptHandler))
+	http.HandleFunc("/todo-item", locked(todoItemHandler))
+	http.HandleFunc("/todo-edit", locked(todoEditHandler))
+	http.HandleFunc("/todo-update", locked(todoUpdateHandler))
+	http.HandleFunc("/todo-list", locked(todoListHandler))
+	http.HandleFunc("/todo-move", locked(todoMoveHandler))
+	http.HandleFunc("/todo-reorder", locked(todoReorderHandler))
+	http.HandleFunc("/todo-priority", locked(todoPriorityHandler))
+	http.HandleFunc("/confirm-todo-bulk", locked(confirmTodoBulkHandler))
+	http.HandleFunc("/todo-bulk", locked(todoBulkHandler))
+	http.HandleFunc("/artists/delete/", locked(deleteArtistHandler))
+	http.HandleFunc("/artists/edit/", locked(editArtistHandler))
 	http.HandleFunc("/artists/update/", updateArtistHandler)
+	http.HandleFunc("/artists/card/", locked(artistCardHandler))
+	http.HandleFunc("/artists/original/", artistOriginalHandler)
+	http.HandleFunc("/artists/images/", artistImagesHandler)
+	http.HandleFunc("/admin", locked(adminPage))
+	http.HandleFunc("/admin/rethumb", locked(rethumbStartHandler))
+	http.HandleFunc("/admin/rethumb-status", locked(rethumbStatusHandler))
+	http.HandleFunc("/admin/check-links", locked(checkLinksStartHandler))
+	http.HandleFunc("/admin/check-links-status", locked(checkLinksStatusHandler))
+	http.HandleFunc("/admin/links", locked(brokenLinksPage))
+	http.HandleFunc("/admin/duplicates", locked(duplicatesPage))
+	http.HandleFunc("/thumb-status/", locked(thumbStatusHandler))
+	http.HandleFunc("/events", eventsHandler)
 
 	// main.go (add before http.ListenAndServe)
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
@@ -676,50 +1025,3 @@ func main() {
 	log.Println("Listening on http://localhost:8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
-
-func thumbnailExists(filename string) bool {
-	// thumbnailPath := filepath.Join("images", filename)
-
-	thumbnailPath := filepath.Join(imagesDir, filename)
-	if _, err := os.Stat(thumbnailPath); err == nil {
-		return true
-	}
-	return false
-}
-
-func fetchAndCreateThumbnail(imageURL, filename string) error {
-	// Ensure images dir exists
-	// imagesDir := "images" / LOOK, using global now ...
-	if err := os.MkdirAll(imagesDir, 0755); err != nil {
-		return err
-	}
-
-	resp, err := http.Get(imageURL)
-	if err != nil {
-		return fmt.Errorf("error fetching image: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("error fetching image: status %d", resp.StatusCode)
-	}
-
-	var buf bytes.Buffer
-	if _, err := io.Copy(&buf, resp.Body); err != nil {
-		return fmt.Errorf("error reading image data: %v", err)
-	}
-
-	img, err := imaging.Decode(&buf)
-	if err != nil {
-		return fmt.Errorf("error decoding image: %v", err)
-	}
-
-	resized := imaging.Resize(img, 200, 0, imaging.Lanczos)
-	outPath := filepath.Join(imagesDir, filename)
-
-	if err := imaging.Save(resized, outPath); err != nil {
-		return fmt.Errorf("error saving image: %v", err)
-	}
-
-	return nil
-}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// paletteSize is how many dominant colours are kept per artist.
+const paletteSize = 5
+
+// paletteSampleWidth is the width the image is shrunk to before
+// clustering; colours, not detail, are what count.
+const paletteSampleWidth = 48
+
+// Swatch is one dominant colour and how much of the image it covers.
+type Swatch struct {
+	R, G, B uint8
+	Share   int // percent of the image
+}
+
+// Palette is an artist's dominant colours, biggest share first.
+type Palette []Swatch
+
+func (s Swatch) Hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", s.R, s.G, s.B)
+}
+
+// hsl returns s's hue in degrees, and saturation and lightness in 0..1.
+func (s Swatch) hsl() (h, sat, l float64) {
+	r, g, b := float64(s.R)/255, float64(s.G)/255, float64(s.B)/255
+	hi, lo := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	l = (hi + lo) / 2
+	if hi == lo {
+		return 0, 0, l
+	}
+	d := hi - lo
+	sat = d / (1 - math.Abs(2*l-1))
+	switch hi {
+	case r:
+		h = math.Mod((g-b)/d+6, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	return h * 60, sat, l
+}
+
+// imagePalette finds the paletteSize dominant colours of img with k-means,
+// seeded from the darkest to the lightest pixels so the same image always
+// gives the same palette.
+func imagePalette(img image.Image) Palette {
+	small := imaging.Resize(img, paletteSampleWidth, 0, imaging.Box)
+	pixels := make([][3]float64, 0, len(small.Pix)/4)
+	for i := 0; i+3 < len(small.Pix); i += 4 {
+		pixels = append(pixels, [3]float64{float64(small.Pix[i]), float64(small.Pix[i+1]), float64(small.Pix[i+2])})
+	}
+	if len(pixels) == 0 {
+		return nil
+	}
+	luma := func(p [3]float64) float64 { return 0.299*p[0] + 0.587*p[1] + 0.114*p[2] }
+	sort.Slice(pixels, func(i, j int) bool { return luma(pixels[i]) < luma(pixels[j]) })
+
+	centres := make([][3]float64, paletteSize)
+	for k := range centres {
+		centres[k] = pixels[(2*k+1)*len(pixels)/(2*paletteSize)]
+	}
+	assigned := make([]int, len(pixels))
+	counts := make([]int, paletteSize)
+	for iter := 0; iter < 10; iter++ {
+		moved := false
+		for i, p := range pixels {
+			best, bestDist := 0, math.Inf(1)
+			for k, c := range centres {
+				d := (p[0]-c[0])*(p[0]-c[0]) + (p[1]-c[1])*(p[1]-c[1]) + (p[2]-c[2])*(p[2]-c[2])
+				if d < bestDist {
+					best, bestDist = k, d
+				}
+			}
+			if iter == 0 || assigned[i] != best {
+				assigned[i], moved = best, true
+			}
+		}
+		if !moved {
+			break
+		}
+		var sums [paletteSize][3]float64
+		clear(counts)
+		for i, p := range pixels {
+			k := assigned[i]
+			counts[k]++
+			sums[k][0], sums[k][1], sums[k][2] = sums[k][0]+p[0], sums[k][1]+p[1], sums[k][2]+p[2]
+		}
+		for k := range centres {
+			if n := float64(counts[k]); n > 0 {
+				centres[k] = [3]float64{sums[k][0] / n, sums[k][1] / n, sums[k][2] / n}
+			}
+		}
+	}
+
+	var palette Palette
+	for k, c := range centres {
+		share := int(math.Round(100 * float64(counts[k]) / float64(len(pixels))))
+		if share == 0 {
+			continue // an empty or negligible cluster
+		}
+		palette = append(palette, Swatch{R: uint8(math.Round(c[0])), G: uint8(math.Round(c[1])), B: uint8(math.Round(c[2])), Share: share})
+	}
+	sort.SliceStable(palette, func(i, j int) bool { return palette[i].Share > palette[j].Share })
+	return palette
+}
+
+// String formats p for the master list, as "3a2f1c/40,aa8866/25".
+func (p Palette) String() string {
+	parts := make([]string, len(p))
+	for i, s := range p {
+		parts[i] = fmt.Sprintf("%02x%02x%02x/%d", s.R, s.G, s.B, s.Share)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParsePalette reads what Palette.String wrote, skipping damaged entries.
+func ParsePalette(text string) Palette {
+	var p Palette
+	for _, part := range strings.Split(text, ",") {
+		hex, share, _ := strings.Cut(strings.TrimSpace(part), "/")
+		rgb, err := strconv.ParseUint(hex, 16, 32)
+		n, err2 := strconv.Atoi(share)
+		if err != nil || err2 != nil || len(hex) != 6 {
+			continue
+		}
+		p = append(p, Swatch{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), Share: n})
+	}
+	return p
+}
+
+// weighted averages f over the swatches by share.
+func (p Palette) weighted(f func(Swatch) float64) float64 {
+	total, sum := 0, 0.0
+	for _, s := range p {
+		total += s.Share
+		sum += float64(s.Share) * f(s)
+	}
+	if total == 0 {
+		return 0
+	}
+	return sum / float64(total)
+}
+
+// Brightness is the image's average lightness, 0 (black) to 1 (white).
+func (p Palette) Brightness() float64 {
+	return p.weighted(func(s Swatch) float64 {
+		return (0.299*float64(s.R) + 0.587*float64(s.G) + 0.114*float64(s.B)) / 255
+	})
+}
+
+// Warmth leans positive for reds, oranges and yellows and negative for
+// blues, -1 to 1; greys count as neither.
+func (p Palette) Warmth() float64 {
+	return p.weighted(func(s Swatch) float64 { return (float64(s.R) - float64(s.B)) / 255 })
+}
+
+// Hue is the hue in degrees of the biggest swatch with a noticeable
+// colour, or -1 for an image of greys, blacks and whites.
+func (p Palette) Hue() float64 {
+	for _, s := range p {
+		if h, sat, l := s.hsl(); sat >= 0.2 && l > 0.1 && l < 0.9 {
+			return h
+		}
+	}
+	return -1
+}
+
+// hueFamilies name ranges of Hue, starting at red; each runs up to the
+// next one's start.
+var hueFamilies = []struct {
+	Name  string
+	Start float64
+}{
+	{"red", 345}, {"orange", 15}, {"yellow", 45}, {"green", 70}, {"blue", 170}, {"purple", 260},
+}
+
+// HueFamily names the family of p's Hue, or "neutral" for greys.
+func (p Palette) HueFamily() string {
+	h := p.Hue()
+	if h < 0 {
+		return "neutral"
+	}
+	name := hueFamilies[0].Name // 345 wraps round to 15
+	for _, f := range hueFamilies[1:] {
+		if h >= f.Start && h < 345 {
+			name = f.Name
+		}
+	}
+	return name
+}
+
+// ColourFilter is a gallery filter, chosen by ?colour=Value.
+type ColourFilter struct {
+	Value, Label string
+	Match        func(Palette) bool
+}
+
+// ColourSort is a gallery order, chosen by ?sort=Value; Key sorts
+// ascending.
+type ColourSort struct {
+	Value, Label string
+	Key          func(Palette) float64
+}
+
+var colourFilters = []ColourFilter{
+	{"warm", "Warm", func(p Palette) bool { return p.Warmth() > 0.08 }},
+	{"cool", "Cool", func(p Palette) bool { return p.Warmth() < -0.03 }},
+	{"light", "Light", func(p Palette) bool { return p.Brightness() > 0.6 }},
+	{"dark", "Dark", func(p Palette) bool { return p.Brightness() < 0.35 }},
+	{"red", "Reds", func(p Palette) bool { return p.HueFamily() == "red" }},
+	{"orange", "Oranges", func(p Palette) bool { return p.HueFamily() == "orange" }},
+	{"yellow", "Yellows", func(p Palette) bool { return p.HueFamily() == "yellow" }},
+	{"green", "Greens", func(p Palette) bool { return p.HueFamily() == "green" }},
+	{"blue", "Blues", func(p Palette) bool { return p.HueFamily() == "blue" }},
+	{"purple", "Purples", func(p Palette) bool { return p.HueFamily() == "purple" }},
+	{"neutral", "Greys", func(p Palette) bool { return p.HueFamily() == "neutral" }},
+}
+
+var colourSorts = []ColourSort{
+	{"hue", "Hue", func(p Palette) float64 {
+		h := p.Hue()
+		switch {
+		case h < 0:
+			return 1000 // greys after every colour
+		case h >= 345:
+			return h - 360 // reds either side of 0 together
+		}
+		return h
+	}},
+	{"warmth", "Warmest first", func(p Palette) float64 { return -p.Warmth() }},
+	{"brightness", "Lightest first", func(p Palette) float64 { return -p.Brightness() }},
+}
+
+// filterByColour keeps the artists matching colour filter value; artists
+// with no palette yet never match. An unknown value keeps everyone.
+func filterByColour(artists []ArtistRecord, value string) []ArtistRecord {
+	for _, f := range colourFilters {
+		if f.Value != value {
+			continue
+		}
+		var kept []ArtistRecord
+		for _, rec := range artists {
+			if len(rec.Palette) > 0 && f.Match(rec.Palette) {
+				kept = append(kept, rec)
+			}
+		}
+		return kept
+	}
+	return artists
+}
+
+// sortByColour returns artists in the order named by value, with those
+// lacking a palette last. An unknown value keeps the order as added.
+func sortByColour(artists []ArtistRecord, value string) []ArtistRecord {
+	for _, s := range colourSorts {
+		if s.Value != value {
+			continue
+		}
+		sorted := append([]ArtistRecord(nil), artists...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			a, b := sorted[i].Palette, sorted[j].Palette
+			if len(a) == 0 || len(b) == 0 {
+				return len(a) > len(b)
+			}
+			return s.Key(a) < s.Key(b)
+		})
+		return sorted
+	}
+	return artists
+}
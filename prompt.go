@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	// "art by X", "in the style of X", "artist: X" ... anywhere in a fragment
+	promptMarker = regexp.MustCompile(`(?i)\b(art by|artwork by|painted by|painting by|illustrated by|illustration by|drawn by|in the style of|style of|inspired by|artist:)\s+(.+)$`)
+	// a bare "by X", only trusted when X is capitalised
+	promptBy = regexp.MustCompile(`(?i)\bby\s+(.+)$`)
+	// "Artists: A, B, C" lists every name on the line
+	promptArtistList = regexp.MustCompile(`(?i)^\s*artists?\s*:\s*(.+)$`)
+	// LoRA tags and Midjourney flags carry no names
+	promptNoise = regexp.MustCompile(`<[^>]*>|--\w+(\s+[^\s,-][^\s,]*)?`)
+	// ":1.2" attention weights after a name
+	promptWeight = regexp.MustCompile(`:\s*[0-9.]+\s*$`)
+	promptAnd    = regexp.MustCompile(`(?i)\s+(?:and|&)\s+`)
+)
+
+// parsePromptArtists pulls artist names out of pasted prompt text. It
+// understands the "art by X," lines the gallery produces as well as the
+// usual variants found online: "by X and Y", "in the style of X",
+// "(artist: X:1.2)", "[by X]" and "Artists: A, B, C". Names come back in
+// prompt order with repeats removed.
+func parsePromptArtists(text string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(raw string) {
+		for _, part := range promptAnd.Split(raw, -1) {
+			name := cleanPromptName(part)
+			key := normalizeName(name)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = promptNoise.ReplaceAllString(line, " ")
+
+		if m := promptArtistList.FindStringSubmatch(line); m != nil {
+			for _, item := range strings.FieldsFunc(m[1], isPromptSeparator) {
+				add(item)
+			}
+			continue
+		}
+
+		for _, frag := range strings.FieldsFunc(line, isPromptSeparator) {
+			frag = strings.NewReplacer("(", " ", ")", " ", "[", " ", "]", " ", "{", " ", "}", " ").Replace(frag)
+			if m := promptMarker.FindStringSubmatch(frag); m != nil {
+				add(m[2])
+			} else if m := promptBy.FindStringSubmatch(frag); m != nil {
+				if r := []rune(strings.TrimSpace(m[1])); len(r) > 0 && unicode.IsUpper(r[0]) {
+					add(m[1])
+				}
+			}
+		}
+	}
+	return names
+}
+
+func isPromptSeparator(r rune) bool {
+	return r == ',' || r == ';' || r == '|' || r == '\n'
+}
+
+// cleanPromptName strips weights, quotes and stray punctuation, and drops
+// anything too long to be a name (a sentence that happened to contain "by").
+func cleanPromptName(s string) string {
+	s = promptWeight.ReplaceAllString(s, "")
+	s = strings.Trim(s, " \t\"'`.!?:*_")
+	s = strings.Join(strings.Fields(s), " ")
+	if len(strings.Fields(s)) > 5 {
+		return ""
+	}
+	return s
+}
+
+// PromptImportResult feeds the import summary shown above the to-do list.
+type PromptImportResult struct {
+	Known     []ArtistRecord // already in the gallery, preselected via SelectURL
+	Queued    []string       // new to-do entries
+	Skipped   []string       // unknown but already queued
+	SelectURL string
+}
+
+// htmx handler: import artist names from pasted prompt text. Known names
+// are offered as a gallery link with them preselected in the working set;
+// unknown names are queued to the to-do list.
+func importPromptHandler(w http.ResponseWriter, r *http.Request) {
+	inMaster := masterIDsByName()
+	queued := make(map[string]bool, len(globalToAddList))
+	for _, e := range globalToAddList {
+		queued[normalizeName(e.Name)] = true
+	}
+
+	var result PromptImportResult
+	var ids []string
+	today := time.Now().Format("2006-01-02")
+	for _, name := range parsePromptArtists(r.FormValue("names")) {
+		key := normalizeName(name)
+		if id := inMaster[key]; id != 0 {
+			for _, rec := range globalMasterList {
+				if rec.ID == id {
+					result.Known = append(result.Known, rec)
+					ids = append(ids, fmt.Sprint(id))
+				}
+			}
+			continue
+		}
+		if queued[key] {
+			result.Skipped = append(result.Skipped, name)
+			continue
+		}
+		globalToAddList = append(globalToAddList, TodoEntry{
+			ID:    nextTodoID(),
+			Name:  name,
+			Note:  "imported from a prompt",
+			Added: today,
+		})
+		queued[key] = true
+		result.Queued = append(result.Queued, name)
+	}
+
+	if len(result.Queued) > 0 {
+		if err := saveToAddListInternal(); err != nil {
+			http.Error(w, "Failed to save to-do list", 500)
+			return
+		}
+	}
+	if len(ids) > 0 {
+		result.SelectURL = "/gallery?select=" + strings.Join(ids, ",")
+	}
+
+	data := struct {
+		AddArtistPageData
+		Import PromptImportResult
+	}{
+		AddArtistPageData: AddArtistPageData{ToAdd: todoListView(r)},
+		Import:            result,
+	}
+	err := templates.ExecuteTemplate(w, "prompt_import_response", data)
+	if err != nil {
+		http.Error(w, "Template error: "+err.Error(), 500)
+	}
+}
@@ -11,6 +11,6 @@ rm -rf data images
 # create the destinations
 mkdir -p data images
 cp backup_data/* data/
-cp backup_images/* images/
+cp -r backup_images/* images/
 echo "prod restored clean:"
 ls data/ images/
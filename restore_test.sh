@@ -10,6 +10,6 @@ rm -rf test_data test_images
 # create the destinations
 mkdir -p test_data test_images
 cp backup_test_data/* test_data/
-cp backup_test_images/* test_images/
+cp -r backup_test_images/* test_images/
 echo "Test restored clean:"
 ls test_data/ test_images/
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rethumbWorkers bounds how many thumbnails a rethumb run builds at once.
+const rethumbWorkers = thumbWorkers
+
+// rethumbLast is the run started from the admin page, guarded by batchMu.
+var rethumbLast *BatchReport
+
+// rethumbTarget is one thumbnail for rethumbAll: an artist's cover, or
+// one of its further images. Both are copied under storeMu; Rec.Images
+// still shares its array with globalMasterList, so it is never read after.
+type rethumbTarget struct {
+	Rec   ArtistRecord // snapshot
+	Image ArtistImage  // the further image, ID 0 for the cover
+}
+
+// rethumbAll rebuilds the thumbnails, covers and further images alike,
+// whose saved widths are picked by want, with current size, crop and
+// orientation handling, from the archived original or else a fresh
+// download of the image URL. Images are fetched and resized outside
+// storeMu; each result is applied and saved as it lands, unless the image
+// was deleted or changed meanwhile. A thumbnail that fails is kept as it
+// was. report is updated under batchMu.
+func rethumbAll(want func(widths []int) bool, report *BatchReport) {
+	storeMu.Lock()
+	var targets []rethumbTarget
+	for _, rec := range globalMasterList {
+		if rec.ThumbStatus == thumbDone && want(rec.ThumbWidths) {
+			targets = append(targets, rethumbTarget{Rec: rec})
+		}
+		for _, img := range rec.Images {
+			if img.Status == thumbDone && want(img.ThumbWidths) {
+				targets = append(targets, rethumbTarget{Rec: rec, Image: img})
+			}
+		}
+	}
+	storeMu.Unlock()
+
+	batchMu.Lock()
+	report.Total = len(targets)
+	batchMu.Unlock()
+
+	jobs := make(chan rethumbTarget)
+	var wg sync.WaitGroup
+	for w := 0; w < rethumbWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range jobs {
+				var err error
+				if t.Image.ID == 0 {
+					err = rethumbOne(t.Rec)
+				} else {
+					err = rethumbImage(t.Rec.ID, t.Image)
+				}
+				batchMu.Lock()
+				report.Done++
+				if err != nil {
+					msg := imageErrorMsg(err)
+					if t.Image.ID != 0 {
+						msg = fmt.Sprintf("Image %d: %s", t.Image.ID, msg)
+					}
+					report.Failures = append(report.Failures, BatchFailure{ID: t.Rec.ID, Name: t.Rec.Name, Msg: msg})
+				} else {
+					report.Succeeded++
+				}
+				batchMu.Unlock()
+			}
+		}()
+	}
+	for _, t := range targets {
+		jobs <- t
+	}
+	close(jobs)
+	wg.Wait()
+
+	batchMu.Lock()
+	report.Running, report.Finished = false, time.Now()
+	batchMu.Unlock()
+}
+
+// rethumbOne rebuilds the thumbnails of snapshot rec and swaps them in.
+func rethumbOne(rec ArtistRecord) error {
+	data, err := sourceImage(rec.Original, rec.ImgURL)
+	if err != nil {
+		return err
+	}
+	filename := fmt.Sprintf("%d-%d.jpg", rec.ID, time.Now().UnixMilli())
+	thumb, err := createThumbnail(data, filename, rec.Crop)
+	if err != nil {
+		return err
+	}
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	i := findArtistIndex(rec.ID)
+	if i == -1 || globalMasterList[i].ImgURL != rec.ImgURL || globalMasterList[i].Crop != rec.Crop ||
+		globalMasterList[i].Thumb != rec.Thumb || globalMasterList[i].ThumbStatus != thumbDone {
+		removeThumbFiles(filename, thumb.Widths)
+		return &ImageError{Msg: "Changed while rebuilding; skipped."}
+	}
+	cur := &globalMasterList[i]
+	setThumbnail(cur, filename, thumb)
+	setOriginal(cur, data) // re-archives a lost original; a no-op otherwise
+	if err := saveMasterListInternal(); err != nil {
+		log.Printf("rethumb: saving master list: %v", err)
+	}
+	publishArtist("artist-updated", *cur)
+	return nil
+}
+
+// rethumbImage rebuilds the thumbnails of img, a copy of one of artist
+// artistID's further images, and swaps them in, as rethumbOne does for the
+// cover.
+func rethumbImage(artistID int, img ArtistImage) error {
+	data, err := sourceImage(img.Original, img.ImgURL)
+	if err != nil {
+		return err
+	}
+	filename := newThumbName(artistID, img.ID)
+	thumb, err := createThumbnail(data, filename, Crop{})
+	if err != nil {
+		return err
+	}
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	i := findArtistIndex(artistID)
+	j := -1
+	if i != -1 {
+		j = findImageIndex(&globalMasterList[i], img.ID)
+	}
+	if j == -1 || globalMasterList[i].Images[j].Thumb != img.Thumb || globalMasterList[i].Images[j].Status != thumbDone {
+		removeThumbFiles(filename, thumb.Widths)
+		return &ImageError{Msg: "Changed while rebuilding; skipped."}
+	}
+	cur := &globalMasterList[i].Images[j]
+	removeThumbFiles(cur.Thumb, cur.ThumbWidths)
+	setImageThumbnail(cur, filename, thumb)
+	setImageOriginal(cur, data) // re-archives a lost original; a no-op otherwise
+	if err := saveMasterListInternal(); err != nil {
+		log.Printf("rethumb: saving master list: %v", err)
+	}
+	publishArtist("artist-updated", globalMasterList[i])
+	return nil
+}
+
+// sourceImage returns a full-size image: the archived original, or else a
+// fresh download of imgURL, e.g. when imagesDir was lost.
+func sourceImage(original, imgURL string) ([]byte, error) {
+	if original != "" {
+		data, err := readOriginal(original)
+		if err == nil {
+			return data, nil
+		}
+		if !fetchableURL(imgURL) {
+			return nil, &ImageError{Msg: "The archived original is missing.", Err: err}
+		}
+	}
+	if !fetchableURL(imgURL) {
+		return nil, &ImageError{Msg: "No archived original and no image URL to fetch."}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTotalTimeout)
+	defer cancel()
+	return fetchImage(ctx, imgURL)
+}
+
+// htmx handler: start rebuilding every thumbnail in the background, unless
+// a run is already going, and show its progress
+func rethumbStartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	batchMu.Lock()
+	if rethumbLast == nil || !rethumbLast.Running {
+		rethumbLast = &BatchReport{Started: time.Now(), Running: true}
+		report := rethumbLast
+		// storeMu is held by this handler; the run waits its turn for it
+		go rethumbAll(func([]int) bool { return true }, report)
+	}
+	batchMu.Unlock()
+	rethumbStatusHandler(w, r)
+}
+
+// htmx handler: progress or outcome of the last rethumb run, which polls
+// itself while the run is going
+func rethumbStatusHandler(w http.ResponseWriter, r *http.Request) {
+	err := templates.ExecuteTemplate(w, "rethumb_status", snapshotReport(rethumbLast))
+	if err != nil {
+		http.Error(w, "Template error: "+err.Error(), 500)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// Thumbnail states stored on ArtistRecord.ThumbStatus. Done is the empty
+// string so existing records need no marker. An artist that needs an image
+// shows a generated placeholder, and is retried while it has an ImgURL.
+const (
+	thumbDone       = ""
+	thumbPending    = "pending"
+	thumbNeedsImage = "needs-image"
+)
+
+const (
+	thumbWorkers    = 3
+	thumbQueueSize  = 64
+	thumbJobTimeout = fetchTotalTimeout + 10*time.Second
+
+	thumbMaxAttempts = 5
+	thumbRetryBase   = time.Minute // doubled after each failed attempt
+)
+
+// thumbRetryDelay is the wait before retrying after the given number of
+// failed attempts: 1, 2, 4, 8 minutes. Records marked failed before
+// attempts were counted read as 0, and wait the first delay.
+func thumbRetryDelay(attempts int) time.Duration {
+	return thumbRetryBase << (max(attempts, 1) - 1)
+}
+
+// thumbJob asks for the thumbnail of one artist to be built from URL.
+type thumbJob struct {
+	ArtistID int
+	ImageID  int // one of the artist's further images, or 0 for its cover
+	URL      string
+	Crop     Crop
+}
+
+// ThumbQueue runs thumbnail jobs on a fixed pool of workers, so adding or
+// editing an artist never waits on a slow image host.
+type ThumbQueue struct {
+	jobs chan thumbJob
+}
+
+func NewThumbQueue(workers, size int) *ThumbQueue {
+	q := &ThumbQueue{jobs: make(chan thumbJob, size)}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue adds a job without blocking, reporting false if the queue is full.
+func (q *ThumbQueue) Enqueue(job thumbJob) bool {
+	select {
+	case q.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *ThumbQueue) work() {
+	for job := range q.jobs {
+		runThumbJob(job)
+	}
+}
+
+var thumbQueue *ThumbQueue
+
+// queueThumbnail marks rec pending and queues a job for its ImgURL. Call
+// with storeMu held; the caller saves the master list.
+func queueThumbnail(rec *ArtistRecord) {
+	rec.ThumbStatus, rec.ThumbMsg = thumbPending, ""
+	if !thumbQueue.Enqueue(thumbJob{ArtistID: rec.ID, URL: rec.ImgURL, Crop: rec.Crop}) {
+		thumbFailed(rec, "Too many thumbnails waiting.")
+	}
+}
+
+// thumbFailed records a failed attempt: the artist keeps (or gets) a
+// placeholder, is marked as needing an image, and is retried with backoff
+// until thumbMaxAttempts. Call with storeMu held.
+func thumbFailed(rec *ArtistRecord, msg string) {
+	rec.ThumbAttempts++
+	rec.ThumbStatus, rec.ThumbMsg = thumbNeedsImage, msg
+	ensurePlaceholder(rec)
+	if rec.RetryPending() {
+		scheduleThumbRetry(rec.ID, rec.ImgURL, thumbRetryDelay(rec.ThumbAttempts))
+	}
+}
+
+// RetryPending reports whether rec's ImgURL will be tried again, so long as
+// it still needs an image.
+func (rec ArtistRecord) RetryPending() bool {
+	return fetchableURL(rec.ImgURL) && rec.ThumbAttempts < thumbMaxAttempts
+}
+
+// ensurePlaceholder gives rec a generated thumbnail if it has none.
+func ensurePlaceholder(rec *ArtistRecord) {
+	if rec.Thumb != "" {
+		return
+	}
+	filename := fmt.Sprintf("%d-placeholder.jpg", rec.ID)
+	if err := createPlaceholder(rec.Name, filename); err != nil {
+		log.Printf("placeholder for %d: %v", rec.ID, err)
+		return
+	}
+	rec.Thumb = filename
+}
+
+// scheduleThumbRetry queues another attempt after delay, unless the artist
+// has been deleted, fixed or given a new URL by then.
+func scheduleThumbRetry(id int, url string, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		i := findArtistIndex(id)
+		if i == -1 || globalMasterList[i].ThumbStatus != thumbNeedsImage || globalMasterList[i].ImgURL != url {
+			return
+		}
+		rec := &globalMasterList[i]
+		queueThumbnail(rec)
+		if err := saveMasterListInternal(); err != nil {
+			log.Printf("thumbnail retry: saving master list: %v", err)
+		}
+		publishArtist("artist-updated", *rec)
+	})
+}
+
+// runThumbJob fetches and resizes outside the lock, then records the
+// outcome and tells open galleries. A result for an artist that has since
+// been deleted, or given a different image URL, is thrown away.
+func runThumbJob(job thumbJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), thumbJobTimeout)
+	defer cancel()
+
+	thumbFile := newThumbName(job.ArtistID, job.ImageID)
+	data, thumb, err := fetchAndCreateThumbnail(ctx, job.URL, thumbFile, job.Crop)
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	if job.ImageID != 0 {
+		applyImageJob(job, thumbFile, data, thumb, err)
+		return
+	}
+
+	i := findArtistIndex(job.ArtistID)
+	if i == -1 || globalMasterList[i].ImgURL != job.URL || globalMasterList[i].Crop != job.Crop ||
+		globalMasterList[i].ThumbStatus == thumbDone {
+		if err == nil {
+			removeThumbFiles(thumbFile, thumb.Widths)
+		}
+		return
+	}
+
+	rec := &globalMasterList[i]
+	if err != nil {
+		log.Printf("thumbnail error for %s (attempt %d): %v", job.URL, rec.ThumbAttempts+1, err)
+		thumbFailed(rec, imageErrorMsg(err))
+	} else {
+		setThumbnail(rec, thumbFile, thumb)
+		rec.ThumbStatus, rec.ThumbMsg, rec.ThumbAttempts = thumbDone, "", 0
+		rec.Link = LinkCheck{Checked: time.Now()} // it just served an image
+		setOriginal(rec, data)
+	}
+
+	if err := saveMasterListInternal(); err != nil {
+		log.Printf("thumbnail: saving master list: %v", err)
+	}
+	publishArtist("artist-updated", *rec)
+}
+
+// setThumbnail makes the files createThumbnail saved as filename rec's
+// thumbnail, removing the ones they replace.
+func setThumbnail(rec *ArtistRecord, filename string, thumb Thumbnail) {
+	removeThumbFiles(rec.Thumb, rec.ThumbWidths)
+	rec.Thumb, rec.ThumbWidths = filename, thumb.Widths
+	rec.ImageHash, rec.Hashed, rec.Palette = thumb.Hash, true, thumb.Palette
+}
+
+// backfillImageDetails finds the hash and palette of artists saved before
+// those were kept, from the archived original, or else the thumbnail,
+// which is close enough. It reports whether any record changed. Call with
+// storeMu held.
+func backfillImageDetails() bool {
+	changed := false
+	for i := range globalMasterList {
+		rec := &globalMasterList[i]
+		if (rec.Hashed && len(rec.Palette) > 0) || rec.ThumbStatus != thumbDone || rec.Thumb == "" {
+			continue
+		}
+		if img, err := originalImage(rec.Original); err == nil {
+			rec.ImageHash, rec.Hashed = imageHash(img), true
+			rec.Palette = imagePalette(cropImage(img, rec.Crop))
+			changed = true
+			continue
+		}
+		img, err := imaging.Open(filepath.Join(imagesDir, rec.Thumb))
+		if err != nil {
+			log.Printf("reading thumbnail of %d: %v", rec.ID, err)
+			continue
+		}
+		rec.ImageHash, rec.Hashed, rec.Palette = imageHash(img), true, imagePalette(img)
+		changed = true
+	}
+	return changed
+}
+
+// originalImage decodes the archived original name, upright and flattened.
+func originalImage(name string) (image.Image, error) {
+	if name == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := readOriginal(name)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	return flatten(img), nil
+}
+
+// prepareReframe builds artist id's thumbnail at crop from its archived
+// original before storeMu is taken, as prepareUpload does for an upload,
+// when the edit form changes only the crop. It returns nil when there is
+// nothing to reframe or no original to do it from. Call without storeMu.
+func prepareReframe(id int, imgURL string, crop Crop) *preparedUpload {
+	storeMu.Lock()
+	i := findArtistIndex(id)
+	var rec ArtistRecord
+	if i != -1 {
+		rec = globalMasterList[i] // only its own fields are read below
+	}
+	storeMu.Unlock()
+	if i == -1 || crop == rec.Crop || rec.ThumbStatus != thumbDone || rec.Original == "" ||
+		(imgURL != "" && imgURL != rec.ImgURL) {
+		return nil
+	}
+
+	data, err := readOriginal(rec.Original)
+	var up *preparedUpload
+	if err == nil {
+		up, err = prepareUpload(data, crop)
+	}
+	if err != nil {
+		log.Printf("reframing %d from original: %v", id, err)
+		return nil
+	}
+	up.Replaces = rec.Thumb
+	return up
+}
+
+// reframeThumbnail applies a changed crop: with up from prepareReframe if
+// the thumbnail is still the one it was built to replace, otherwise by
+// fetching ImgURL again. It reports whether a job was queued. Call with
+// storeMu held.
+func reframeThumbnail(rec *ArtistRecord, up *preparedUpload) bool {
+	if up != nil && rec.ThumbStatus == thumbDone && rec.Thumb == up.Replaces {
+		setThumbnail(rec, up.keep(newThumbName(rec.ID, 0)), up.Thumb)
+		return false
+	}
+	up.discard()
+	if !fetchableURL(rec.ImgURL) {
+		return false
+	}
+	rec.ThumbAttempts = 0
+	queueThumbnail(rec)
+	return true
+}
+
+// requeuePendingThumbnails restarts jobs cut short by a restart, and
+// picks the retry schedule back up for artists still needing an image.
+func requeuePendingThumbnails() {
+	for i := range globalMasterList {
+		rec := &globalMasterList[i]
+		switch {
+		case rec.ThumbStatus == thumbPending:
+			queueThumbnail(rec)
+		case rec.ThumbStatus == thumbNeedsImage && rec.RetryPending():
+			scheduleThumbRetry(rec.ID, rec.ImgURL, thumbRetryDelay(rec.ThumbAttempts))
+		}
+		for j := range rec.Images {
+			if rec.Images[j].Status == thumbPending {
+				queueArtistImage(rec, &rec.Images[j])
+			}
+		}
+	}
+}
+
+// htmx handler: thumbnail progress line under the add and edit forms,
+// which keeps polling itself until the job is done
+func thumbStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/thumb-status/"))
+	i := findArtistIndex(id)
+	if i == -1 {
+		// Deleted meanwhile: an empty swap removes the poller
+		return
+	}
+	err := templates.ExecuteTemplate(w, "thumb_status", globalMasterList[i])
+	if err != nil {
+		http.Error(w, "Template error: "+err.Error(), 500)
+	}
+}
+
+// htmx handler: a single grid_item, polled by cards waiting on a thumbnail
+func artistCardHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/artists/card/"))
+	i := findArtistIndex(id)
+	if i == -1 {
+		return
+	}
+	err := templates.ExecuteTemplate(w, "grid_item", globalMasterList[i])
+	if err != nil {
+		http.Error(w, "Template error: "+err.Error(), 500)
+	}
+}
+
+// handler: the archived full-size image of an artist, opened in the
+// gallery lightbox; ?image=id picks one of its further images. Registered
+// without locked(): the file is served after storeMu is released, so a slow
+// download doesn't hold up every other request.
+func artistOriginalHandler(w http.ResponseWriter, r *http.Request) {
+	original := originalFor(r)
+	if original == "" {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(imagesDir, originalsDir, original))
+}
+
+// originalFor looks up the archived original file artistOriginalHandler
+// serves, or "" if there is none.
+func originalFor(r *http.Request) string {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/artists/original/"))
+	i := findArtistIndex(id)
+	if i == -1 {
+		return ""
+	}
+	if imageID, _ := strconv.Atoi(r.FormValue("image")); imageID != 0 {
+		if j := findImageIndex(&globalMasterList[i], imageID); j != -1 {
+			return globalMasterList[i].Images[j].Original
+		}
+		return ""
+	}
+	return globalMasterList[i].Original
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// TodoEntry is one name waiting in artists_to_add.txt. The ID is stable
+// across restarts, so endpoints can address an entry without relying on
+// its spelling.
+type TodoEntry struct {
+	ID     int
+	Name   string
+	Note   string // where we came across the name
+	Source string // link to an example, optional
+	Added  string // YYYY-MM-DD, empty for names appended by hand
+
+	Priority bool
+	Skip     bool // triaged as not worth adding, kept so it isn't re-queued
+}
+
+// FormDesc is the starting description when the entry populates the add
+// form, so the note and source aren't lost. Kept on one line because the
+// master list stores descriptions one per line.
+func (e TodoEntry) FormDesc() string {
+	desc := e.Note
+	if e.Source != "" {
+		desc = strings.TrimSpace(desc + " Source: " + e.Source)
+	}
+	return desc
+}
+
+// TodoItemView is one to-do entry as the todo_list_items partial sees it,
+// flagged against the master list and the rest of the to-do list.
+type TodoItemView struct {
+	TodoEntry
+	MasterID    int  // set when the name is already in the gallery
+	PossibleDup bool // another to-do entry normalises to the same name
+	Reorderable bool // list is in saved order, so move controls make sense
+}
+
+// TodoList is the to-do list as rendered: the entries matching Query in
+// display order, and how many entries there are in all.
+type TodoList struct {
+	Items []TodoItemView
+	Query string
+	Total int
+}
+
+// Sort orders for the todo_list_items partial. The saved order in
+// artists_to_add.txt is always the manual one; the others only change how
+// the list is shown.
+const (
+	todoSortManual   = ""
+	todoSortPriority = "priority"
+	todoSortAlpha    = "alpha"
+	todoSortOldest   = "oldest"
+)
+
+var accentFolds = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'ł': "l", 'ľ': "l",
+	'ñ': "n", 'ń': "n", 'ň': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o",
+	'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o", 'œ': "oe", 'ř': "r", 'ś': "s",
+	'š': "s", 'ş': "s", 'ß': "ss", 'ť': "t", 'ţ': "t", 'þ': "th", 'ù': "u",
+	'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ý': "y",
+	'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// foldAccents lowercases s and replaces common Latin accented letters with
+// their plain ASCII spelling, so "Mucha" matches "Múcha".
+func foldAccents(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if f, ok := accentFolds[r]; ok {
+			b.WriteString(f)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// normalizeName reduces a name to lowercase letters and digits with accents
+// folded, so "Jean-Michel Basquiat" and "jean michel basquiat" compare equal.
+func normalizeName(name string) string {
+	var b strings.Builder
+	for _, r := range foldAccents(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// masterIDsByName maps normalised master list names to their record IDs.
+func masterIDsByName() map[string]int {
+	ids := make(map[string]int, len(globalMasterList))
+	for _, rec := range globalMasterList {
+		ids[normalizeName(rec.Name)] = rec.ID
+	}
+	return ids
+}
+
+// todoListView flags each to-do entry that is already in the gallery or
+// that looks like a second spelling of another entry, then filters and
+// orders the list by the "q" and "sort" values the index page sends with
+// every request. The filter is a substring match on name and note with
+// case and accents ignored.
+func todoListView(r *http.Request) TodoList {
+	sortBy, query := todoSortManual, ""
+	if r != nil {
+		sortBy = r.FormValue("sort")
+		query = strings.TrimSpace(r.FormValue("q"))
+	}
+	needle := foldAccents(query)
+
+	inMaster := masterIDsByName()
+	counts := make(map[string]int, len(globalToAddList))
+	for _, e := range globalToAddList {
+		counts[normalizeName(e.Name)]++
+	}
+
+	items := make([]TodoItemView, 0, len(globalToAddList))
+	for _, e := range globalToAddList {
+		if needle != "" &&
+			!strings.Contains(foldAccents(e.Name), needle) &&
+			!strings.Contains(foldAccents(e.Note), needle) {
+			continue
+		}
+		key := normalizeName(e.Name)
+		items = append(items, TodoItemView{
+			TodoEntry:   e,
+			MasterID:    inMaster[key],
+			PossibleDup: counts[key] > 1,
+			Reorderable: sortBy == todoSortManual,
+		})
+	}
+
+	switch sortBy {
+	case todoSortPriority:
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].Priority && !items[j].Priority
+		})
+	case todoSortAlpha:
+		sort.SliceStable(items, func(i, j int) bool {
+			return foldAccents(items[i].Name) < foldAccents(items[j].Name)
+		})
+	case todoSortOldest:
+		// Entries without a date were appended by hand, so go last
+		sort.SliceStable(items, func(i, j int) bool {
+			a, b := items[i].Added, items[j].Added
+			if a == "" || b == "" {
+				return a != "" && b == ""
+			}
+			return a < b
+		})
+	}
+	return TodoList{Items: items, Query: query, Total: len(globalToAddList)}
+}
+
+// todoItemView returns the flagged view of a single entry, as it shows in
+// the list view r asks for, so that a sorted list gets no drag handle.
+func todoItemView(r *http.Request, id int) (TodoItemView, bool) {
+	for _, item := range todoListView(r).Items {
+		if item.ID == id {
+			return item, true
+		}
+	}
+	return TodoItemView{}, false
+}
+
+// todoIDFromRequest reads a to-do entry ID from form field key, 0 if absent.
+func todoIDFromRequest(r *http.Request, key string) int {
+	id, _ := strconv.Atoi(strings.TrimSpace(r.FormValue(key)))
+	return id
+}
+
+func findTodo(id int) (TodoEntry, bool) {
+	for _, e := range globalToAddList {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return TodoEntry{}, false
+}
+
+// removeTodo drops the entry with this ID, reporting whether it was there.
+func removeTodo(id int) bool {
+	for i, e := range globalToAddList {
+		if e.ID == id {
+			globalToAddList = append(globalToAddList[:i], globalToAddList[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// lastTodoID is the highest to-do ID given out. It only ever grows, and is
+// saved with the list, so an ID left in an open page never comes to mean a
+// different entry.
+var lastTodoID int
+
+func nextTodoID() int {
+	lastTodoID++
+	return lastTodoID
+}
+
+func saveToAddListInternal() error {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("last:%d\n\n", lastTodoID))
+	for _, e := range globalToAddList {
+		builder.WriteString(fmt.Sprintf("id:%d\nn:%s\n", e.ID, e.Name))
+		if e.Note != "" {
+			builder.WriteString("note:" + e.Note + "\n")
+		}
+		if e.Source != "" {
+			builder.WriteString("src:" + e.Source + "\n")
+		}
+		if e.Added != "" {
+			builder.WriteString("date:" + e.Added + "\n")
+		}
+		if e.Priority {
+			builder.WriteString("p:1\n")
+		}
+		if e.Skip {
+			builder.WriteString("skip:1\n")
+		}
+		builder.WriteString("\n")
+	}
+	return os.WriteFile(filepath.Join(dataDir, "artists_to_add.txt"), []byte(builder.String()), 0644)
+}
+
+// oneLine collapses a form value to a single trimmed line, since each
+// field is stored on its own line.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// htmx handler: render one to-do entry, e.g. when an inline edit is cancelled
+func todoItemHandler(w http.ResponseWriter, r *http.Request) {
+	item, ok := todoItemView(r, todoIDFromRequest(r, "id"))
+	if !ok {
+		http.Error(w, "To-do entry not found", 404)
+		return
+	}
+	err := templates.ExecuteTemplate(w, "todo_list_item", item)
+	if err != nil {
+		http.Error(w, "Template error: "+err.Error(), 500)
+	}
+}
+
+// htmx handler: swap a to-do entry for its inline note/source/date form
+func todoEditHandler(w http.ResponseWriter, r *http.Request) {
+	entry, ok := findTodo(todoIDFromRequest(r, "id"))
+	if !ok {
+		http.Error(w, "To-do entry not found", 404)
+		return
+	}
+	err := templates.ExecuteTemplate(w, "todo_edit_item", entry)
+	if err != nil {
+		http.Error(w, "Template error: "+err.Error(), 500)
+	}
+}
+
+// htmx handler: save an entry's note, source and date and render it again
+func todoUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	id := todoIDFromRequest(r, "id")
+	for i := range globalToAddList {
+		if globalToAddList[i].ID != id {
+			continue
+		}
+		globalToAddList[i].Note = oneLine(r.FormValue("note"))
+		globalToAddList[i].Source = oneLine(r.FormValue("source"))
+		globalToAddList[i].Added = oneLine(r.FormValue("added"))
+		if err := saveToAddListInternal(); err != nil {
+			http.Error(w, "Failed to save to-do list", 500)
+			return
+		}
+		todoItemHandler(w, r)
+		return
+	}
+	http.Error(w, "To-do entry not found", 404)
+}
+
+// renderTodoList writes the todo_list_items partial in the requested order.
+func renderTodoList(w http.ResponseWriter, r *http.Request) {
+	data := AddArtistPageData{
+		ToAdd: todoListView(r),
+	}
+	err := templates.ExecuteTemplate(w, "todo_list_items", data)
+	if err != nil {
+		http.Error(w, "Template error: "+err.Error(), 500)
+	}
+}
+
+// htmx handler: re-render the list, e.g. when the sort order changes
+func todoListHandler(w http.ResponseWriter, r *http.Request) {
+	renderTodoList(w, r)
+}
+
+// htmx handler: move one entry up, down or to the top of the saved order
+func todoMoveHandler(w http.ResponseWriter, r *http.Request) {
+	id := todoIDFromRequest(r, "id")
+	i := -1
+	for j, e := range globalToAddList {
+		if e.ID == id {
+			i = j
+			break
+		}
+	}
+	if i == -1 {
+		http.Error(w, "To-do entry not found", 404)
+		return
+	}
+
+	list := globalToAddList
+	switch r.FormValue("dir") {
+	case "up":
+		if i > 0 {
+			list[i-1], list[i] = list[i], list[i-1]
+		}
+	case "down":
+		if i < len(list)-1 {
+			list[i], list[i+1] = list[i+1], list[i]
+		}
+	case "top":
+		e := list[i]
+		copy(list[1:i+1], list[:i])
+		list[0] = e
+	default:
+		http.Error(w, "Unknown direction", 400)
+		return
+	}
+
+	if err := saveToAddListInternal(); err != nil {
+		http.Error(w, "Failed to save to-do list", 500)
+		return
+	}
+	renderTodoList(w, r)
+}
+
+// htmx handler: save a new order after a drag. "ids" holds the entries as
+// they now appear on the page; they are put back into the slots they held
+// in the saved list, so reordering a filtered view leaves hidden entries
+// where they were.
+func todoReorderHandler(w http.ResponseWriter, r *http.Request) {
+	var order []TodoEntry
+	listed := make(map[int]bool)
+	for _, s := range strings.Split(r.FormValue("ids"), ",") {
+		id, _ := strconv.Atoi(strings.TrimSpace(s))
+		if e, ok := findTodo(id); ok && !listed[id] {
+			order = append(order, e)
+			listed[id] = true
+		}
+	}
+
+	next := 0
+	for i, e := range globalToAddList {
+		if listed[e.ID] && next < len(order) {
+			globalToAddList[i] = order[next]
+			next++
+		}
+	}
+
+	if err := saveToAddListInternal(); err != nil {
+		http.Error(w, "Failed to save to-do list", 500)
+		return
+	}
+	renderTodoList(w, r)
+}
+
+// htmx handler: toggle an entry's priority flag
+func todoPriorityHandler(w http.ResponseWriter, r *http.Request) {
+	id := todoIDFromRequest(r, "id")
+	for i := range globalToAddList {
+		if globalToAddList[i].ID == id {
+			globalToAddList[i].Priority = !globalToAddList[i].Priority
+			if err := saveToAddListInternal(); err != nil {
+				http.Error(w, "Failed to save to-do list", 500)
+				return
+			}
+			renderTodoList(w, r)
+			return
+		}
+	}
+	http.Error(w, "To-do entry not found", 404)
+}
+
+// Bulk actions offered for checked to-do entries.
+var todoBulkActions = map[string]string{
+	"delete": "Delete",
+	"top":    "Move to top",
+	"skip":   "Mark as skip",
+	"unskip": "Clear skip",
+	"merge":  "Merge spellings",
+}
+
+// selectedTodos returns the checked entries in saved-list order.
+func selectedTodos(r *http.Request) []TodoEntry {
+	_ = r.ParseForm()
+	want := make(map[int]bool)
+	for _, s := range r.Form["ids"] {
+		id, _ := strconv.Atoi(strings.TrimSpace(s))
+		want[id] = true
+	}
+	var picked []TodoEntry
+	for _, e := range globalToAddList {
+		if want[e.ID] {
+			picked = append(picked, e)
+		}
+	}
+	return picked
+}
+
+// htmx handler: one confirmation dialog for a bulk action on checked entries
+func confirmTodoBulkHandler(w http.ResponseWriter, r *http.Request) {
+	action := r.FormValue("bulk_action")
+	data := struct {
+		Action  string
+		Label   string
+		Entries []TodoEntry
+		Msg     string
+	}{
+		Action:  action,
+		Label:   todoBulkActions[action],
+		Entries: selectedTodos(r),
+	}
+	switch {
+	case data.Label == "":
+		data.Msg = "Choose an action first."
+	case len(data.Entries) == 0:
+		data.Msg = "Check one or more names in the list first."
+	case action == "merge" && len(data.Entries) < 2:
+		data.Msg = "Check at least two spellings to merge."
+	}
+
+	err := templates.ExecuteTemplate(w, "confirm_bulk_content", data)
+	if err != nil {
+		http.Error(w, "Template error: "+err.Error(), 500)
+	}
+}
+
+// htmx handler: apply a confirmed bulk action and return the list items
+func todoBulkHandler(w http.ResponseWriter, r *http.Request) {
+	picked := selectedTodos(r)
+	selected := make(map[int]bool, len(picked))
+	for _, e := range picked {
+		selected[e.ID] = true
+	}
+
+	switch r.FormValue("bulk_action") {
+	case "delete":
+		kept := globalToAddList[:0]
+		for _, e := range globalToAddList {
+			if !selected[e.ID] {
+				kept = append(kept, e)
+			}
+		}
+		globalToAddList = kept
+	case "top":
+		reordered := make([]TodoEntry, 0, len(globalToAddList))
+		reordered = append(reordered, picked...)
+		for _, e := range globalToAddList {
+			if !selected[e.ID] {
+				reordered = append(reordered, e)
+			}
+		}
+		globalToAddList = reordered
+	case "skip", "unskip":
+		skip := r.FormValue("bulk_action") == "skip"
+		for i := range globalToAddList {
+			if selected[globalToAddList[i].ID] {
+				globalToAddList[i].Skip = skip
+			}
+		}
+	case "merge":
+		if len(picked) < 2 {
+			http.Error(w, "Need at least two entries to merge", 400)
+			return
+		}
+		mergeTodos(picked, todoIDFromRequest(r, "keep"))
+	default:
+		http.Error(w, "Unknown bulk action", 400)
+		return
+	}
+
+	if err := saveToAddListInternal(); err != nil {
+		http.Error(w, "Failed to save to-do list", 500)
+		return
+	}
+	renderTodoList(w, r)
+}
+
+// mergeTodos folds the picked entries into the one with ID keep (the first
+// if keep isn't among them). Notes are combined, the other spellings are
+// recorded in the note, and the earliest date and any priority survive.
+func mergeTodos(picked []TodoEntry, keep int) {
+	target := picked[0]
+	for _, e := range picked {
+		if e.ID == keep {
+			target = e
+		}
+	}
+
+	var notes, others []string
+	for _, e := range picked {
+		if e.Note != "" && !slices.Contains(notes, e.Note) {
+			notes = append(notes, e.Note)
+		}
+		if e.ID == target.ID {
+			continue
+		}
+		others = append(others, e.Name)
+		if target.Source == "" {
+			target.Source = e.Source
+		}
+		if e.Added != "" && (target.Added == "" || e.Added < target.Added) {
+			target.Added = e.Added
+		}
+		target.Priority = target.Priority || e.Priority
+	}
+	notes = append(notes, "also spelled "+strings.Join(others, ", "))
+	target.Note = strings.Join(notes, "; ")
+
+	merged := make(map[int]bool, len(picked))
+	for _, e := range picked {
+		merged[e.ID] = true
+	}
+	kept := globalToAddList[:0]
+	for _, e := range globalToAddList {
+		switch {
+		case e.ID == target.ID:
+			kept = append(kept, target)
+		case !merged[e.ID]:
+			kept = append(kept, e)
+		}
+	}
+	globalToAddList = kept
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// imgSourceUploaded stands in for ImgURL when the image was uploaded or
+// pasted from the browser, so there is nothing to fetch or retry.
+const imgSourceUploaded = "uploaded"
+
+// uploadMaxBytes caps an uploaded image, matching the download limit.
+const uploadMaxBytes = fetchMaxBytes
+
+// uploadFormMaxBytes caps the whole form body: room for the image as
+// base64 in a data: URL, plus the other fields.
+const uploadFormMaxBytes = uploadMaxBytes*4/3 + 1<<20
+
+// fetchableURL reports whether url is something the thumbnail queue can
+// download, as opposed to empty or an uploaded image.
+func fetchableURL(url string) bool {
+	return url != "" && url != imgSourceUploaded
+}
+
+// parseUploadForm parses a possibly multipart form, refusing bodies much
+// larger than an image upload may be. Plain urlencoded forms pass through.
+func parseUploadForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, uploadFormMaxBytes)
+	// Files over this spill to a temp file; text fields such as a data:
+	// URL get this much again on top of Go's 10 MB allowance.
+	err := r.ParseMultipartForm(uploadMaxBytes)
+	var tooBig *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooBig):
+		return tooLargeError()
+	case err != nil && !errors.Is(err, http.ErrNotMultipart):
+		return &ImageError{Msg: "Could not read the uploaded file.", Err: err}
+	}
+	return nil
+}
+
+// formImage returns the image sent with an add or edit form: a file
+// chosen or pasted into img_file, or else a data: URL pasted into img_url.
+// It returns nil when the form only has an ordinary URL, or nothing.
+func formImage(r *http.Request) ([]byte, error) {
+	data, err := readUpload(r, "img_file")
+	if data != nil || err != nil {
+		return data, err
+	}
+	if imgURL := strings.TrimSpace(r.FormValue("img_url")); isDataURL(imgURL) {
+		return decodeDataURL(imgURL)
+	}
+	return nil, nil
+}
+
+func isDataURL(s string) bool {
+	return len(s) >= 5 && strings.EqualFold(s[:5], "data:")
+}
+
+// decodeDataURL decodes a data:[<type>][;base64],<data> URL, the form a
+// copied image often takes, and checks it like an upload.
+func decodeDataURL(s string) ([]byte, error) {
+	meta, payload, ok := strings.Cut(s[len("data:"):], ",")
+	if !ok {
+		return nil, &ImageError{Msg: "The pasted data: URL is incomplete."}
+	}
+	var data []byte
+	var err error
+	if strings.HasSuffix(strings.ToLower(meta), ";base64") {
+		// Copies often pick up line breaks or lose their padding
+		payload = strings.Join(strings.Fields(payload), "")
+		data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+	} else {
+		var text string
+		text, err = url.PathUnescape(payload)
+		data = []byte(text)
+	}
+	if err != nil {
+		return nil, &ImageError{Msg: "The pasted data: URL is damaged.", Err: err}
+	}
+	if len(data) > uploadMaxBytes {
+		return nil, tooLargeError()
+	}
+	if err := checkImageData(data, "The pasted image"); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// checkImageData makes sure data is a type decodeImage understands, naming
+// it as what in the message.
+func checkImageData(data []byte, what string) error {
+	kind, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if !decodableTypes[kind] {
+		return &ImageError{Msg: fmt.Sprintf("%s is %s, not a JPEG, PNG, GIF or WebP image.", what, kind)}
+	}
+	return nil
+}
+
+// readUpload returns the image file sent as field, or nil if none was
+// chosen. The data is checked to be a type decodeImage understands.
+func readUpload(r *http.Request, field string) ([]byte, error) {
+	file, header, err := r.FormFile(field)
+	if errors.Is(err, http.ErrMissingFile) || errors.Is(err, http.ErrNotMultipart) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &ImageError{Msg: "Could not read the uploaded file.", Err: err}
+	}
+	defer file.Close()
+
+	if header.Size > uploadMaxBytes {
+		return nil, tooLargeError()
+	}
+	data, err := io.ReadAll(io.LimitReader(file, uploadMaxBytes+1))
+	if err != nil {
+		return nil, &ImageError{Msg: "Could not read the uploaded file.", Err: err}
+	}
+	if len(data) > uploadMaxBytes {
+		return nil, tooLargeError()
+	}
+
+	if err := checkImageData(data, header.Filename); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// preparedUpload is an uploaded image thumbnailed under a temporary name,
+// waiting for useUploadedImage or addArtistImage to give it to an artist.
+type preparedUpload struct {
+	File     string // see tempThumbName
+	Thumb    Thumbnail
+	Data     []byte // archived once the upload is used
+	Replaces string // for prepareReframe, the thumbnail it was built to replace
+}
+
+// prepareUpload does the slow part of taking an upload, decoding and
+// resizing data, so that it runs before storeMu is taken. Archiving the
+// original waits for the lock, so removeOriginal can't race it.
+func prepareUpload(data []byte, crop Crop) (*preparedUpload, error) {
+	filename, err := tempThumbName()
+	if err != nil {
+		return nil, err
+	}
+	thumb, err := createThumbnail(data, filename, crop)
+	if err != nil {
+		removeThumbFiles(filename, nil)
+		return nil, err
+	}
+	return &preparedUpload{File: filename, Thumb: thumb, Data: data}, nil
+}
+
+// keep gives the thumbnail files their final name, to, and returns the
+// name they ended up under, the temporary one if they couldn't be moved.
+func (up *preparedUpload) keep(to string) string {
+	if err := renameThumbFiles(up.File, to, up.Thumb.Widths); err != nil {
+		log.Printf("renaming uploaded thumbnail: %v", err)
+		return up.File
+	}
+	return to
+}
+
+// discard removes the files of an upload that went unused, say because the
+// form failed validation.
+func (up *preparedUpload) discard() {
+	if up == nil {
+		return
+	}
+	removeThumbFiles(up.File, up.Thumb.Widths)
+}
+
+// useUploadedImage makes up rec's image, replacing the old thumbnail and
+// overriding any job still pending for a URL. Call with storeMu held; the
+// caller saves the master list.
+func useUploadedImage(rec *ArtistRecord, up *preparedUpload) {
+	setThumbnail(rec, up.keep(newThumbName(rec.ID, 0)), up.Thumb)
+	rec.ImgURL = imgSourceUploaded
+	rec.Link = LinkCheck{}
+	rec.ThumbStatus, rec.ThumbMsg, rec.ThumbAttempts = thumbDone, "", 0
+	setOriginal(rec, up.Data)
+}